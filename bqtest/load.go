@@ -0,0 +1,436 @@
+package bqtest
+
+import (
+	"math/big"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+	"github.com/arquivei/foundationkit/errors"
+)
+
+// Load loads the row, that has the schema, into dst, following the rules of
+// (*bigquery.RowIterator).Next: dst may be a bigquery.ValueLoader, a *[]bigquery.Value,
+// a *map[string]bigquery.Value or a pointer to a struct.
+//
+// Struct fields match the columns by their bigquery tag or, without a tag, by their name,
+// ignoring case, and the fields of embedded structs are promoted as in Go. Unmatched columns
+// and fields are ignored. Columns are only loaded into fields of their Go type or of their
+// bigquery.Null* type, except INTEGER columns, that are loaded into any integer field that
+// holds them. NULL values can't be loaded into fields that have no NULL, like strings and
+// numbers, so such rows fail as they do with the client.
+func Load(dst interface{}, schema bigquery.Schema, row []bigquery.Value) error {
+	switch d := dst.(type) {
+	case bigquery.ValueLoader:
+		return d.Load(row, schema)
+	case *[]bigquery.Value:
+		*d = append([]bigquery.Value(nil), row...)
+		return nil
+	case *map[string]bigquery.Value:
+		if *d == nil {
+			*d = make(map[string]bigquery.Value, len(schema))
+		}
+		for i, f := range schema {
+			(*d)[f.Name] = mapValue(f, row[i])
+		}
+		return nil
+	}
+
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.Errorf("bqtest: cannot load into %T (need pointer to []Value, map[string]Value, or struct)", dst)
+	}
+	return loadStruct(rv.Elem(), schema, row)
+}
+
+// mapValue returns the value with its records converted to maps, like bigquery does.
+func mapValue(f *bigquery.FieldSchema, v bigquery.Value) bigquery.Value {
+	if f.Type != bigquery.RecordFieldType || v == nil {
+		return v
+	}
+	record := func(v bigquery.Value) bigquery.Value {
+		values, ok := v.([]bigquery.Value)
+		if !ok {
+			return v
+		}
+		m := make(map[string]bigquery.Value, len(f.Schema))
+		for i, c := range f.Schema {
+			if i < len(values) {
+				m[c.Name] = mapValue(c, values[i])
+			}
+		}
+		return m
+	}
+	if !f.Repeated {
+		return record(v)
+	}
+	elems, ok := v.([]bigquery.Value)
+	if !ok {
+		return v
+	}
+	out := make([]bigquery.Value, len(elems))
+	for i, e := range elems {
+		out[i] = record(e)
+	}
+	return out
+}
+
+func loadStruct(v reflect.Value, schema bigquery.Schema, row []bigquery.Value) error {
+	fields := structFields(v.Type())
+	for i, f := range schema {
+		sf, ok := matchField(fields, f.Name)
+		if !ok || i >= len(row) {
+			continue
+		}
+		fv, err := fieldByIndex(v, sf.index)
+		if err != nil {
+			return err
+		}
+		if err := loadField(fv, sf.name, f, row[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// structField is a field of a struct that a column can be loaded into.
+type structField struct {
+	name   string
+	index  []int
+	typ    reflect.Type
+	tagged bool
+}
+
+// structFields returns the exported fields of the struct, ordered by their indexes. The
+// fields of embedded structs without a tag are promoted as in Go: they are shadowed by the
+// fields of shallower structs, and dropped if ambiguous, unless exactly one of the
+// ambiguous fields is tagged.
+func structFields(t reflect.Type) []structField {
+	var fields []structField
+	shadowed := map[string]bool{}
+	visited := map[reflect.Type]bool{}
+	level := []structField{{typ: t}}
+	for len(level) > 0 {
+		var found, next []structField
+		for _, embedded := range level {
+			if visited[embedded.typ] {
+				continue
+			}
+			for i := 0; i < embedded.typ.NumField(); i++ {
+				f := embedded.typ.Field(i)
+				tag, _, _ := strings.Cut(f.Tag.Get("bigquery"), ",")
+				if tag == "-" || (!f.IsExported() && !f.Anonymous) {
+					continue
+				}
+				index := append(slices.Clone(embedded.index), i)
+				ft := f.Type
+				if ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if f.Anonymous && tag == "" && ft.Kind() == reflect.Struct {
+					next = append(next, structField{index: index, typ: ft})
+					continue
+				}
+				if !f.IsExported() {
+					continue
+				}
+				name := f.Name
+				if tag != "" {
+					name = tag
+				}
+				found = append(found, structField{name: name, index: index, typ: f.Type, tagged: tag != ""})
+			}
+		}
+		for _, embedded := range level {
+			visited[embedded.typ] = true
+		}
+
+		for _, f := range found {
+			if shadowed[f.name] {
+				continue
+			}
+			shadowed[f.name] = true
+			if dominant, ok := dominantField(found, f.name); ok {
+				fields = append(fields, dominant)
+			}
+		}
+		level = next
+	}
+	slices.SortFunc(fields, func(a, b structField) int { return slices.Compare(a.index, b.index) })
+	return fields
+}
+
+// dominantField returns the field with the name at a single embedding depth: the only one
+// or, if there are many, the only tagged one.
+func dominantField(fields []structField, name string) (structField, bool) {
+	var dominant structField
+	count, tagged := 0, 0
+	for _, f := range fields {
+		if f.name != name {
+			continue
+		}
+		count++
+		if f.tagged {
+			tagged++
+			dominant = f
+		} else if count == 1 {
+			dominant = f
+		}
+	}
+	return dominant, count == 1 || tagged == 1
+}
+
+// matchField finds the field of the column, preferring an exact match to one ignoring case.
+func matchField(fields []structField, column string) (structField, bool) {
+	for _, f := range fields {
+		if f.name == column {
+			return f, true
+		}
+	}
+	for _, f := range fields {
+		if strings.EqualFold(f.name, column) {
+			return f, true
+		}
+	}
+	return structField{}, false
+}
+
+// fieldByIndex is like reflect.Value.FieldByIndex, but allocates the nil pointers to embedded structs.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, error) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}, errors.Errorf("bqtest: cannot allocate the unexported embedded %s", v.Type())
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, nil
+}
+
+// loadField loads the value of the column into the field with the name.
+func loadField(fv reflect.Value, name string, f *bigquery.FieldSchema, v bigquery.Value) error {
+	if !f.Repeated {
+		return loadValue(fv, name, f, v)
+	}
+
+	if fv.Kind() != reflect.Slice && fv.Kind() != reflect.Array {
+		return errors.Errorf("bqtest: repeated column %s requires a slice or array, but field %s has type %s", f.Name, name, fv.Type())
+	}
+	elems, ok := v.([]bigquery.Value)
+	if !ok && v != nil {
+		return errors.Errorf("bqtest: repeated column %s should be a []bigquery.Value, got %T", f.Name, v)
+	}
+	n := len(elems)
+	if fv.Kind() == reflect.Slice {
+		switch {
+		case fv.Len() < n:
+			fv.Set(reflect.MakeSlice(fv.Type(), n, n))
+		case fv.Len() > n:
+			fv.SetLen(n)
+		}
+	}
+	for i := 0; i < fv.Len(); i++ {
+		if i >= n {
+			fv.Index(i).Set(reflect.Zero(fv.Type().Elem()))
+			continue
+		}
+		if err := loadValue(fv.Index(i), name, f, elems[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadValue loads a single value of the column into the field with the name.
+func loadValue(fv reflect.Value, name string, f *bigquery.FieldSchema, v bigquery.Value) error {
+	if f.Type != bigquery.RecordFieldType {
+		return loadScalar(fv, name, f, v)
+	}
+
+	t := fv.Type()
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return errors.Errorf("bqtest: field %s has type %s, expected struct or *struct", name, fv.Type())
+	}
+	if v == nil {
+		if fv.Kind() != reflect.Ptr {
+			return nullError(name, fv.Type())
+		}
+		fv.Set(reflect.Zero(fv.Type()))
+		return nil
+	}
+	values, ok := v.([]bigquery.Value)
+	if !ok {
+		return errors.Errorf("bqtest: record %s should be a []bigquery.Value, got %T", f.Name, v)
+	}
+	if fv.Kind() == reflect.Ptr {
+		if fv.IsNil() {
+			fv.Set(reflect.New(t))
+		}
+		fv = fv.Elem()
+	}
+	return loadStruct(fv, f.Schema, values)
+}
+
+// loadScalar loads a value of a column that isn't a record into the field with the name.
+// The field must be of a type the client loads the column's type into.
+func loadScalar(fv reflect.Value, name string, f *bigquery.FieldSchema, v bigquery.Value) error {
+	if want := valueType(f.Type); v != nil && want != nil && reflect.TypeOf(v) != want {
+		return errors.Errorf("bqtest: column %s of type %s should be a %s, got %T", f.Name, f.Type, want, v)
+	}
+
+	t := fv.Type()
+	if null, ok := nullValue(f.Type, v); ok && t == null.Type() {
+		fv.Set(null)
+		return nil
+	}
+
+	var assignable bool
+	switch f.Type {
+	case bigquery.StringFieldType, bigquery.GeographyFieldType, bigquery.JSONFieldType:
+		assignable = t.Kind() == reflect.String
+	case bigquery.BytesFieldType:
+		if t == reflect.TypeOf([]byte(nil)) {
+			// []byte is the only field without a NULL type that holds NULL.
+			b, _ := v.([]byte)
+			fv.SetBytes(b)
+			return nil
+		}
+	case bigquery.IntegerFieldType:
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint8, reflect.Uint16, reflect.Uint32:
+			assignable = true
+		}
+	case bigquery.FloatFieldType:
+		assignable = t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64
+	case bigquery.BooleanFieldType:
+		assignable = t.Kind() == reflect.Bool
+	case bigquery.NumericFieldType, bigquery.BigNumericFieldType:
+		if t == reflect.TypeOf(&big.Rat{}) {
+			// *big.Rat is nil for NULL.
+			r, _ := v.(*big.Rat)
+			fv.Set(reflect.ValueOf(r))
+			return nil
+		}
+	default:
+		assignable = valueType(f.Type) != nil && t == valueType(f.Type)
+	}
+	if !assignable {
+		return errors.Errorf("bqtest: column %s of type %s is not assignable to field %s of type %s", f.Name, f.Type, name, t)
+	}
+	if v == nil {
+		return nullError(name, t)
+	}
+
+	rv := reflect.ValueOf(v)
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if fv.OverflowInt(rv.Int()) {
+			return errors.Errorf("bqtest: value %v of column %s overflows field %s of type %s", v, f.Name, name, t)
+		}
+		fv.SetInt(rv.Int())
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		if rv.Int() < 0 || fv.OverflowUint(uint64(rv.Int())) {
+			return errors.Errorf("bqtest: value %v of column %s overflows field %s of type %s", v, f.Name, name, t)
+		}
+		fv.SetUint(uint64(rv.Int()))
+	case reflect.Float32, reflect.Float64:
+		if fv.OverflowFloat(rv.Float()) {
+			return errors.Errorf("bqtest: value %v of column %s overflows field %s of type %s", v, f.Name, name, t)
+		}
+		fv.SetFloat(rv.Float())
+	case reflect.String:
+		fv.SetString(rv.String())
+	case reflect.Bool:
+		fv.SetBool(rv.Bool())
+	default:
+		fv.Set(rv)
+	}
+	return nil
+}
+
+// nullError is the error of loading NULL into a field that can't hold it.
+func nullError(name string, t reflect.Type) error {
+	return errors.Errorf("bqtest: NULL cannot be assigned to field `%s` of type %s", name, t)
+}
+
+// valueType returns the Go type of the values of the column type, as returned by the client,
+// or nil for the types the fake doesn't check.
+func valueType(ft bigquery.FieldType) reflect.Type {
+	switch ft {
+	case bigquery.StringFieldType, bigquery.GeographyFieldType, bigquery.JSONFieldType:
+		return reflect.TypeOf("")
+	case bigquery.BytesFieldType:
+		return reflect.TypeOf([]byte(nil))
+	case bigquery.IntegerFieldType:
+		return reflect.TypeOf(int64(0))
+	case bigquery.FloatFieldType:
+		return reflect.TypeOf(float64(0))
+	case bigquery.BooleanFieldType:
+		return reflect.TypeOf(false)
+	case bigquery.TimestampFieldType:
+		return reflect.TypeOf(time.Time{})
+	case bigquery.DateFieldType:
+		return reflect.TypeOf(civil.Date{})
+	case bigquery.TimeFieldType:
+		return reflect.TypeOf(civil.Time{})
+	case bigquery.DateTimeFieldType:
+		return reflect.TypeOf(civil.DateTime{})
+	case bigquery.NumericFieldType, bigquery.BigNumericFieldType:
+		return reflect.TypeOf(&big.Rat{})
+	}
+	return nil
+}
+
+// nullValue returns the bigquery.Null* value of the column type holding the value, which
+// is invalid for NULL. It returns false if the column type has no such type.
+func nullValue(ft bigquery.FieldType, v bigquery.Value) (reflect.Value, bool) {
+	valid := v != nil
+	var null interface{}
+	switch ft {
+	case bigquery.StringFieldType:
+		s, _ := v.(string)
+		null = bigquery.NullString{StringVal: s, Valid: valid}
+	case bigquery.GeographyFieldType:
+		s, _ := v.(string)
+		null = bigquery.NullGeography{GeographyVal: s, Valid: valid}
+	case bigquery.JSONFieldType:
+		s, _ := v.(string)
+		null = bigquery.NullJSON{JSONVal: s, Valid: valid}
+	case bigquery.IntegerFieldType:
+		i, _ := v.(int64)
+		null = bigquery.NullInt64{Int64: i, Valid: valid}
+	case bigquery.FloatFieldType:
+		f, _ := v.(float64)
+		null = bigquery.NullFloat64{Float64: f, Valid: valid}
+	case bigquery.BooleanFieldType:
+		b, _ := v.(bool)
+		null = bigquery.NullBool{Bool: b, Valid: valid}
+	case bigquery.TimestampFieldType:
+		t, _ := v.(time.Time)
+		null = bigquery.NullTimestamp{Timestamp: t, Valid: valid}
+	case bigquery.DateFieldType:
+		d, _ := v.(civil.Date)
+		null = bigquery.NullDate{Date: d, Valid: valid}
+	case bigquery.TimeFieldType:
+		t, _ := v.(civil.Time)
+		null = bigquery.NullTime{Time: t, Valid: valid}
+	case bigquery.DateTimeFieldType:
+		dt, _ := v.(civil.DateTime)
+		null = bigquery.NullDateTime{DateTime: dt, Valid: valid}
+	default:
+		return reflect.Value{}, false
+	}
+	return reflect.ValueOf(null), true
+}
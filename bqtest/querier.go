@@ -0,0 +1,225 @@
+// Package bqtest provides fakes of bigqueryutil's Querier for unit tests.
+package bqtest
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/arquivei/bigqueryutil"
+	"github.com/arquivei/foundationkit/errors"
+	"google.golang.org/api/iterator"
+)
+
+// ErrNoMatch is returned by the fake Querier when no registered result matches the query.
+var ErrNoMatch = errors.New("bqtest: no result matches the query")
+
+// Matcher checks if a query should get a result.
+type Matcher func(q bigqueryutil.BuiltQuery) bool
+
+// Any matches every query.
+func Any() Matcher {
+	return func(bigqueryutil.BuiltQuery) bool { return true }
+}
+
+// SQLContains matches the queries whose SQL contains substr.
+func SQLContains(substr string) Matcher {
+	return func(q bigqueryutil.BuiltQuery) bool { return strings.Contains(q.SQL, substr) }
+}
+
+// SQLMatches matches the queries whose SQL matches the regular expression.
+func SQLMatches(re *regexp.Regexp) Matcher {
+	return func(q bigqueryutil.BuiltQuery) bool { return re.MatchString(q.SQL) }
+}
+
+// Result is what the fake Querier returns for the queries that match it.
+type Result struct {
+	// Schema is the schema of the rows.
+	Schema bigquery.Schema
+	// Rows hold the values of the rows, in the order of the schema. Records are []bigquery.Value
+	// and repeated columns are []bigquery.Value of their elements.
+	Rows [][]bigquery.Value
+	// Err, if set, is returned by Query instead of the rows.
+	Err error
+}
+
+// RecordedQuery is a query received by the fake Querier.
+type RecordedQuery struct {
+	SQL        string
+	Parameters []bigquery.QueryParameter
+	Config     bigqueryutil.JobConfig
+}
+
+// Querier is a fake bigqueryutil.Querier that records the queries it receives and returns
+// the canned results registered for them. It is safe for concurrent use.
+type Querier struct {
+	mu      sync.Mutex
+	results []matchedResult
+	queries []RecordedQuery
+}
+
+type matchedResult struct {
+	matcher Matcher
+	result  Result
+}
+
+// NewQuerier returns a fake Querier without results.
+func NewQuerier() *Querier {
+	return &Querier{}
+}
+
+// On registers the result of the queries matched by the matcher. Results are matched in the
+// order they were registered.
+func (q *Querier) On(m Matcher, r Result) *Querier {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.results = append(q.results, matchedResult{matcher: m, result: r})
+	return q
+}
+
+// Query implements bigqueryutil.Querier. It returns ErrNoMatch if no registered result matches the query.
+func (q *Querier) Query(ctx context.Context, bq bigqueryutil.BuiltQuery, cfg bigqueryutil.JobConfig) (bigqueryutil.RowIterator, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r, ok := q.result(bq, cfg)
+	if !ok {
+		return nil, errors.Errorf("%w: %s", ErrNoMatch, bq.SQL)
+	}
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	return NewRowIterator(r.Schema, r.Rows), nil
+}
+
+// result records the query and returns the first registered result that matches it,
+// and false if none matches.
+func (q *Querier) result(bq bigqueryutil.BuiltQuery, cfg bigqueryutil.JobConfig) (Result, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.queries = append(q.queries, RecordedQuery{
+		SQL:        bq.SQL,
+		Parameters: append([]bigquery.QueryParameter(nil), bq.Parameters...),
+		Config:     cfg,
+	})
+	for _, r := range q.results {
+		if r.matcher(bq) {
+			return r.result, true
+		}
+	}
+	return Result{}, false
+}
+
+// Queries returns the queries received so far.
+func (q *Querier) Queries() []RecordedQuery {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]RecordedQuery(nil), q.queries...)
+}
+
+// TestingT is the subset of testing.TB used by the assertions.
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// AssertQueryCount checks that the querier received n queries.
+func (q *Querier) AssertQueryCount(t TestingT, n int) bool {
+	t.Helper()
+	if got := len(q.Queries()); got != n {
+		t.Errorf("expected %d queries, but got %d", n, got)
+		return false
+	}
+	return true
+}
+
+// AssertQueryContains checks that the SQL of some received query contains substr.
+func (q *Querier) AssertQueryContains(t TestingT, substr string) bool {
+	t.Helper()
+	queries := q.Queries()
+	for _, rq := range queries {
+		if strings.Contains(rq.SQL, substr) {
+			return true
+		}
+	}
+	t.Errorf("no query contains %q, got:\n%s", substr, formatQueries(queries))
+	return false
+}
+
+// AssertParam checks that some received query has the parameter with the value.
+func (q *Querier) AssertParam(t TestingT, name string, value interface{}) bool {
+	t.Helper()
+	queries := q.Queries()
+	for _, rq := range queries {
+		for _, p := range rq.Parameters {
+			if p.Name == name && reflect.DeepEqual(p.Value, value) {
+				return true
+			}
+		}
+	}
+	t.Errorf("no query has the parameter %s = %#v, got:\n%s", name, value, formatQueries(queries))
+	return false
+}
+
+func formatQueries(queries []RecordedQuery) string {
+	if len(queries) == 0 {
+		return "no queries"
+	}
+	sb := strings.Builder{}
+	for i, rq := range queries {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(rq.SQL)
+		for _, p := range rq.Parameters {
+			sb.WriteString("\n\t@")
+			sb.WriteString(p.Name)
+			sb.WriteString(" = ")
+			sb.WriteString(fmt.Sprintf("%#v", p.Value))
+		}
+	}
+	return sb.String()
+}
+
+// RowIterator iterates over the rows of a Result.
+type RowIterator struct {
+	schema bigquery.Schema
+	rows   [][]bigquery.Value
+	total  uint64
+	closed bool
+}
+
+// NewRowIterator returns an iterator over the rows, that have the schema.
+func NewRowIterator(schema bigquery.Schema, rows [][]bigquery.Value) *RowIterator {
+	return &RowIterator{schema: schema, rows: rows, total: uint64(len(rows))}
+}
+
+// Next loads the next row into dst, as *bigquery.RowIterator does.
+func (i *RowIterator) Next(dst interface{}) error {
+	if i.closed || len(i.rows) == 0 {
+		return iterator.Done
+	}
+	row := i.rows[0]
+	i.rows = i.rows[1:]
+	return Load(dst, i.schema, row)
+}
+
+// Schema implements bigqueryutil.RowIterator.
+func (i *RowIterator) Schema() bigquery.Schema {
+	return i.schema
+}
+
+// TotalRows implements bigqueryutil.RowIterator.
+func (i *RowIterator) TotalRows() uint64 {
+	return i.total
+}
+
+// Close implements bigqueryutil.RowIterator.
+func (i *RowIterator) Close() error {
+	i.closed = true
+	return nil
+}
@@ -0,0 +1,219 @@
+package bqtest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/arquivei/bigqueryutil"
+	"github.com/arquivei/foundationkit/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type emitter struct {
+	CNPJ string
+	Name string `bigquery:"xNome"`
+}
+
+type document struct {
+	AccessKey string
+	Emitter   *emitter `bigquery:"emit"`
+	Items     []int
+	Total     float64
+	Owner     string
+}
+
+var documentSchema = bigquery.Schema{
+	{Name: "AccessKey", Type: bigquery.StringFieldType},
+	{Name: "emit", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+		{Name: "CNPJ", Type: bigquery.StringFieldType},
+		{Name: "xNome", Type: bigquery.StringFieldType},
+	}},
+	{Name: "Items", Type: bigquery.IntegerFieldType, Repeated: true},
+	{Name: "Total", Type: bigquery.FloatFieldType},
+}
+
+// recordingT is a TestingT that records the failures.
+type recordingT struct {
+	failures []string
+}
+
+func (t *recordingT) Helper() {}
+
+func (t *recordingT) Errorf(format string, args ...interface{}) {
+	t.failures = append(t.failures, fmt.Sprintf(format, args...))
+}
+
+func TestQuerier(t *testing.T) {
+	t.Parallel()
+	builder, err := bigqueryutil.NewBuilder(
+		bigqueryutil.QueryBuilderSpec{SQLQuery: "SELECT %s FROM %s WHERE %s"},
+		bigqueryutil.TableRef{Dataset: "documents", Table: "nfe"},
+	)
+	assert.NoError(t, err)
+	query, err := builder.Build(context.Background(), bigqueryutil.QueryRequest{
+		Projection: []bigqueryutil.ProjectionField{{Path: "AccessKey"}},
+		Filter: struct {
+			Owner string
+		}{Owner: "19427033000140"},
+	})
+	assert.NoError(t, err)
+
+	failure := errors.New("quota exceeded")
+	querier := NewQuerier().
+		On(SQLContains("FROM `documents.cte`"), Result{Err: failure}).
+		On(SQLContains("FROM `documents.nfe`"), Result{
+			Schema: documentSchema,
+			Rows: [][]bigquery.Value{
+				{"a", []bigquery.Value{"19427033000140", "Arquivei"}, []bigquery.Value{int64(1), int64(2)}, 10.5},
+				{"b", nil, nil, 3.0},
+			},
+		})
+
+	var documents []document
+	for d, err := range bigqueryutil.Query[document](context.Background(), querier, query) {
+		assert.NoError(t, err)
+		documents = append(documents, d)
+	}
+	assert.Equal(t, []document{
+		{AccessKey: "a", Emitter: &emitter{CNPJ: "19427033000140", Name: "Arquivei"}, Items: []int{1, 2}, Total: 10.5},
+		{AccessKey: "b", Total: 3},
+	}, documents)
+
+	var maps []map[string]bigquery.Value
+	for m, err := range bigqueryutil.Query[map[string]bigquery.Value](context.Background(), querier, query) {
+		assert.NoError(t, err)
+		maps = append(maps, m)
+	}
+	assert.Equal(t, map[string]bigquery.Value{
+		"AccessKey": "a",
+		"emit":      map[string]bigquery.Value{"CNPJ": "19427033000140", "xNome": "Arquivei"},
+		"Items":     []bigquery.Value{int64(1), int64(2)},
+		"Total":     10.5,
+	}, maps[0])
+
+	_, err = querier.Query(context.Background(), bigqueryutil.BuiltQuery{SQL: "SELECT * FROM `documents.cte`"}, bigqueryutil.JobConfig{})
+	assert.Equal(t, failure, err)
+	_, err = querier.Query(context.Background(), bigqueryutil.BuiltQuery{SQL: "SELECT * FROM `documents.nfse`"}, bigqueryutil.JobConfig{})
+	assert.ErrorIs(t, err, ErrNoMatch)
+
+	querier.AssertQueryCount(t, 4)
+	querier.AssertQueryContains(t, "SELECT AccessKey FROM `documents.nfe` WHERE Owner = @Owner")
+	querier.AssertParam(t, "Owner", "19427033000140")
+
+	rt := &recordingT{}
+	assert.False(t, querier.AssertQueryContains(rt, "FROM `documents.nfe_v2`"))
+	assert.False(t, querier.AssertParam(rt, "Owner", "03160081000185"))
+	assert.False(t, querier.AssertQueryCount(rt, 1))
+	assert.Len(t, rt.failures, 3)
+	assert.Contains(t, rt.failures[1], `@Owner = "19427033000140"`)
+}
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+	row := []bigquery.Value{"a", []bigquery.Value{"19427033000140", "Arquivei"}, nil, "10.5"}
+
+	var values []bigquery.Value
+	assert.NoError(t, Load(&values, documentSchema, row))
+	assert.Equal(t, row, values)
+
+	var d document
+	assert.EqualError(t, Load(&d, documentSchema, row), "bqtest: column Total of type FLOAT should be a float64, got string")
+
+	assert.Error(t, Load(d, documentSchema, row))
+}
+
+func TestLoadStruct(t *testing.T) {
+	t.Parallel()
+
+	type Audit struct {
+		Owner string
+		Total int64 `bigquery:"Amount"`
+	}
+	type embeddingDocument struct {
+		*Audit
+		AccessKey string
+		Total     float64
+	}
+	type nullableDocument struct {
+		AccessKey bigquery.NullString
+		Emitter   *emitter `bigquery:"emit"`
+		Total     bigquery.NullFloat64
+	}
+	type intDocument struct {
+		Total int64
+	}
+	type smallDocument struct {
+		Items []uint8
+	}
+
+	schema := append(bigquery.Schema{
+		{Name: "Owner", Type: bigquery.StringFieldType},
+		{Name: "Amount", Type: bigquery.IntegerFieldType},
+	}, documentSchema...)
+
+	tests := []struct {
+		name    string
+		dst     interface{}
+		row     []bigquery.Value
+		want    interface{}
+		wantErr string
+	}{
+		{
+			name: "embedded structs are flattened",
+			dst:  &embeddingDocument{},
+			row:  []bigquery.Value{"19427033000140", int64(3), "a", nil, nil, 10.5},
+			want: &embeddingDocument{Audit: &Audit{Owner: "19427033000140", Total: 3}, AccessKey: "a", Total: 10.5},
+		},
+		{
+			name: "NULL is loaded into the null types",
+			dst:  &nullableDocument{},
+			row:  []bigquery.Value{"19427033000140", int64(3), nil, nil, nil, 10.5},
+			want: &nullableDocument{Total: bigquery.NullFloat64{Float64: 10.5, Valid: true}},
+		},
+		{
+			name:    "NULL into a string",
+			dst:     &document{},
+			row:     []bigquery.Value{"19427033000140", int64(3), nil, nil, nil, 10.5},
+			wantErr: "bqtest: NULL cannot be assigned to field `AccessKey` of type string",
+		},
+		{
+			name:    "NULL into a float",
+			dst:     &document{},
+			row:     []bigquery.Value{"19427033000140", int64(3), "a", nil, nil, nil},
+			wantErr: "bqtest: NULL cannot be assigned to field `Total` of type float64",
+		},
+		{
+			name:    "FLOAT into an integer",
+			dst:     &intDocument{},
+			row:     []bigquery.Value{"19427033000140", int64(3), "a", nil, nil, 10.5},
+			wantErr: "bqtest: column Total of type FLOAT is not assignable to field Total of type int64",
+		},
+		{
+			name:    "INTEGER overflows the field",
+			dst:     &smallDocument{},
+			row:     []bigquery.Value{"19427033000140", int64(3), "a", nil, []bigquery.Value{int64(256)}, 10.5},
+			wantErr: "bqtest: value 256 of column Items overflows field Items of type uint8",
+		},
+		{
+			name:    "INTEGER holds a float",
+			dst:     &document{},
+			row:     []bigquery.Value{"19427033000140", int64(3), "a", nil, []bigquery.Value{1.5}, 10.5},
+			wantErr: "bqtest: column Items of type INTEGER should be a int64, got float64",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			err := Load(test.dst, schema, test.row)
+			if test.wantErr != "" {
+				assert.EqualError(t, err, test.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, test.dst)
+		})
+	}
+}
@@ -0,0 +1,348 @@
+package bqtest
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+	"github.com/arquivei/bigqueryutil"
+	"github.com/arquivei/foundationkit/errors"
+	bq "google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/iterator"
+)
+
+// RecorderMode defines if a Recorder records or replays the queries.
+type RecorderMode int
+
+const (
+	// Replay serves the queries from the golden files, failing if a query wasn't recorded.
+	Replay RecorderMode = iota
+	// Record runs the queries with the querier and stores their results in golden files.
+	Record
+)
+
+// Recorder is a bigqueryutil.Querier that records query traffic to golden files and replays it offline.
+//
+// The golden files are named by the fingerprint of the query, the SHA256 of its SQL and
+// parameters, and hold the SQL, the parameters, the schema and the rows of the result.
+// The rows are stored in the format of the BigQuery REST API, so they decode as real results.
+type Recorder struct {
+	dir     string
+	mode    RecorderMode
+	querier bigqueryutil.Querier
+}
+
+// NewRecorder returns a recorder of the golden files in dir. In Record mode, the queries
+// are run with the querier, that may be nil in Replay mode.
+func NewRecorder(dir string, mode RecorderMode, querier bigqueryutil.Querier) *Recorder {
+	return &Recorder{dir: dir, mode: mode, querier: querier}
+}
+
+// RecorderModeFromEnv returns Record if the environment variable is set to a true value,
+// like "1" or "true", and Replay otherwise.
+func RecorderModeFromEnv(name string) RecorderMode {
+	if record, _ := strconv.ParseBool(os.Getenv(name)); record {
+		return Record
+	}
+	return Replay
+}
+
+// Query implements bigqueryutil.Querier.
+func (r *Recorder) Query(ctx context.Context, q bigqueryutil.BuiltQuery, cfg bigqueryutil.JobConfig) (bigqueryutil.RowIterator, error) {
+	path := r.path(q)
+	if r.mode == Record {
+		return r.record(ctx, path, q, cfg)
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, errors.Errorf("bqtest: query %s wasn't recorded, run it in Record mode: %s", Fingerprint(q), q.SQL)
+	}
+	if err != nil {
+		return nil, errors.Errorf("bqtest: reading golden file: %w", err)
+	}
+	schema, rows, err := decodeGolden(data)
+	if err != nil {
+		return nil, errors.Errorf("bqtest: %s: %w", path, err)
+	}
+	return NewRowIterator(schema, rows), nil
+}
+
+// record runs the query, stores its result in the golden file and returns an iterator over it.
+func (r *Recorder) record(ctx context.Context, path string, q bigqueryutil.BuiltQuery, cfg bigqueryutil.JobConfig) (bigqueryutil.RowIterator, error) {
+	if r.querier == nil {
+		return nil, errors.New("bqtest: recording requires a querier")
+	}
+	it, err := r.querier.Query(ctx, q, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	var rows [][]bigquery.Value
+	for {
+		var row []bigquery.Value
+		err := it.Next(&row)
+		if stderrors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+	schema := it.Schema()
+
+	data, err := encodeGolden(q, schema, rows)
+	if err != nil {
+		return nil, errors.Errorf("bqtest: %w", err)
+	}
+	if err := os.MkdirAll(r.dir, 0o755); err != nil {
+		return nil, errors.Errorf("bqtest: creating golden files directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return nil, errors.Errorf("bqtest: writing golden file: %w", err)
+	}
+	return NewRowIterator(schema, rows), nil
+}
+
+func (r *Recorder) path(q bigqueryutil.BuiltQuery) string {
+	return filepath.Join(r.dir, Fingerprint(q)+".json")
+}
+
+// Fingerprint returns the fingerprint of the query, that names its golden file.
+// Queries with the same SQL and parameters have the same fingerprint, whatever the order of
+// their named parameters and the location or monotonic clock reading of their times.
+func Fingerprint(q bigqueryutil.BuiltQuery) string {
+	params := slices.Clone(q.Parameters)
+	// Positional parameters have no name, so the stable sort keeps their order.
+	sort.SliceStable(params, func(i, j int) bool { return params[i].Name < params[j].Name })
+
+	h := sha256.New()
+	h.Write([]byte(q.SQL))
+	for _, p := range params {
+		fmt.Fprintf(h, "\x00%s\x00%T\x00%s", p.Name, p.Value, canonicalValue(reflect.ValueOf(p.Value)))
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// canonicalValue formats the parameter value so that equal values are formatted the same.
+// Times are formatted in UTC, without their monotonic clock reading, and numbers in their
+// shortest exact form.
+func canonicalValue(v reflect.Value) string {
+	if !v.IsValid() {
+		return "NULL"
+	}
+	switch x := v.Interface().(type) {
+	case time.Time:
+		return x.Round(0).UTC().Format(time.RFC3339Nano)
+	case civil.Date:
+		return x.String()
+	case civil.Time:
+		return x.String()
+	case civil.DateTime:
+		return x.String()
+	case *big.Rat:
+		if x == nil {
+			return "NULL"
+		}
+		return x.RatString()
+	case big.Rat:
+		return x.RatString()
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return "NULL"
+		}
+		return canonicalValue(v.Elem())
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64)
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return fmt.Sprintf("%x", v.Interface())
+		}
+		elems := make([]string, v.Len())
+		for i := range elems {
+			elems[i] = canonicalValue(v.Index(i))
+		}
+		return "[" + strings.Join(elems, ",") + "]"
+	case reflect.Map:
+		entries := make([]string, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			entries = append(entries, canonicalValue(iter.Key())+":"+canonicalValue(iter.Value()))
+		}
+		sort.Strings(entries)
+		return "{" + strings.Join(entries, ",") + "}"
+	case reflect.Struct:
+		fields := make([]string, v.NumField())
+		for i := range fields {
+			f := v.Type().Field(i)
+			if !f.IsExported() {
+				// Values with internal state can only be compared by their format.
+				return fmt.Sprintf("%v", v.Interface())
+			}
+			fields[i] = f.Name + ":" + canonicalValue(v.Field(i))
+		}
+		return "{" + strings.Join(fields, ",") + "}"
+	}
+	return fmt.Sprintf("%v", v.Interface())
+}
+
+// golden is the content of a golden file.
+type golden struct {
+	SQL        string            `json:"sql"`
+	Parameters []goldenParameter `json:"parameters,omitempty"`
+	Schema     json.RawMessage   `json:"schema"`
+	Rows       []*bq.TableRow    `json:"rows"`
+}
+
+// goldenParameter documents a parameter of the query. It's not used to replay it.
+type goldenParameter struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func encodeGolden(q bigqueryutil.BuiltQuery, schema bigquery.Schema, rows [][]bigquery.Value) ([]byte, error) {
+	g := golden{SQL: q.SQL}
+	for _, p := range q.Parameters {
+		g.Parameters = append(g.Parameters, goldenParameter{Name: p.Name, Value: canonicalValue(reflect.ValueOf(p.Value))})
+	}
+	var err error
+	if g.Schema, err = schema.ToJSONFields(); err != nil {
+		return nil, err
+	}
+	if g.Rows, err = encodeRows(schema, rows); err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(g, "", "  ")
+}
+
+func decodeGolden(data []byte) (bigquery.Schema, [][]bigquery.Value, error) {
+	var g golden
+	if err := json.Unmarshal(data, &g); err != nil {
+		return nil, nil, err
+	}
+	schema, err := bigquery.SchemaFromJSON(g.Schema)
+	if err != nil {
+		return nil, nil, err
+	}
+	rows := make([][]bigquery.Value, len(g.Rows))
+	for i, row := range g.Rows {
+		values, err := decodeRecord(schema, row.F)
+		if err != nil {
+			return nil, nil, err
+		}
+		rows[i] = values
+	}
+	return schema, rows, nil
+}
+
+func decodeRecord(schema bigquery.Schema, cells []*bq.TableCell) ([]bigquery.Value, error) {
+	if len(cells) != len(schema) {
+		return nil, errors.Errorf("record has %d values, but the schema has %d columns", len(cells), len(schema))
+	}
+	values := make([]bigquery.Value, len(cells))
+	for i, c := range cells {
+		v, err := decodeValue(schema[i], schema[i].Repeated, c.V)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// decodeValue decodes a value in the format of the BigQuery REST API, as encoded by encodeValue.
+func decodeValue(f *bigquery.FieldSchema, repeated bool, v interface{}) (bigquery.Value, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	if repeated {
+		cells, ok := v.([]interface{})
+		if !ok {
+			return nil, errors.Errorf("repeated column %s should be a list, got %T", f.Name, v)
+		}
+		values := make([]bigquery.Value, len(cells))
+		for i, c := range cells {
+			cell, ok := c.(map[string]interface{})
+			if !ok {
+				return nil, errors.Errorf("repeated column %s should hold cells, got %T", f.Name, c)
+			}
+			value, err := decodeValue(f, false, cell["v"])
+			if err != nil {
+				return nil, err
+			}
+			values[i] = value
+		}
+		return values, nil
+	}
+
+	if f.Type == bigquery.RecordFieldType {
+		record, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, errors.Errorf("record %s should be an object, got %T", f.Name, v)
+		}
+		data, err := json.Marshal(record)
+		if err != nil {
+			return nil, err
+		}
+		var row bq.TableRow
+		if err := json.Unmarshal(data, &row); err != nil {
+			return nil, err
+		}
+		return decodeRecord(f.Schema, row.F)
+	}
+
+	s, ok := v.(string)
+	if !ok {
+		return nil, errors.Errorf("column %s should be a string, got %T", f.Name, v)
+	}
+	switch f.Type {
+	case bigquery.BytesFieldType:
+		return base64.StdEncoding.DecodeString(s)
+	case bigquery.IntegerFieldType:
+		return strconv.ParseInt(s, 10, 64)
+	case bigquery.FloatFieldType:
+		return strconv.ParseFloat(s, 64)
+	case bigquery.BooleanFieldType:
+		return strconv.ParseBool(s)
+	case bigquery.TimestampFieldType:
+		micros, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return time.UnixMicro(micros).UTC(), nil
+	case bigquery.DateFieldType:
+		return civil.ParseDate(s)
+	case bigquery.TimeFieldType:
+		return civil.ParseTime(s)
+	case bigquery.DateTimeFieldType:
+		return civil.ParseDateTime(s)
+	case bigquery.NumericFieldType, bigquery.BigNumericFieldType:
+		r, ok := new(big.Rat).SetString(s)
+		if !ok {
+			return nil, errors.Errorf("invalid numeric %q of column %s", s, f.Name)
+		}
+		return r, nil
+	}
+	return s, nil
+}
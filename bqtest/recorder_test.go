@@ -0,0 +1,169 @@
+package bqtest
+
+import (
+	"context"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+	"github.com/arquivei/bigqueryutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	schema := bigquery.Schema{
+		{Name: "AccessKey", Type: bigquery.StringFieldType},
+		{Name: "emit", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+			{Name: "CNPJ", Type: bigquery.StringFieldType},
+			{Name: "xNome", Type: bigquery.StringFieldType},
+		}},
+		{Name: "Items", Type: bigquery.IntegerFieldType, Repeated: true},
+		{Name: "Total", Type: bigquery.NumericFieldType},
+		{Name: "CreatedAt", Type: bigquery.TimestampFieldType},
+		{Name: "EmissionDate", Type: bigquery.DateFieldType},
+		{Name: "IsTaker", Type: bigquery.BooleanFieldType},
+	}
+	rows := [][]bigquery.Value{
+		{
+			"a", []bigquery.Value{"19427033000140", "Arquivei"}, []bigquery.Value{int64(1), int64(2)},
+			big.NewRat(21, 2), time.Date(2022, 1, 2, 3, 4, 5, 6000, time.UTC), civil.Date{Year: 2022, Month: 1, Day: 2}, true,
+		},
+		{"b", nil, []bigquery.Value{}, nil, nil, nil, false},
+	}
+	query := bigqueryutil.BuiltQuery{
+		SQL:        "SELECT * FROM `documents.nfe` WHERE Owner = @Owner",
+		Parameters: []bigquery.QueryParameter{{Name: "Owner", Value: "19427033000140"}},
+	}
+
+	querier := NewQuerier().On(Any(), Result{Schema: schema, Rows: rows})
+	recorder := NewRecorder(dir, Record, querier)
+	recorded := readAll(t, recorder, query)
+	assert.Equal(t, rows, recorded)
+	querier.AssertQueryCount(t, 1)
+
+	golden, err := os.ReadFile(filepath.Join(dir, Fingerprint(query)+".json"))
+	require.NoError(t, err)
+	assert.Contains(t, string(golden), `"sql": "SELECT * FROM `+"`documents.nfe`"+` WHERE Owner = @Owner"`)
+
+	replayer := NewRecorder(dir, Replay, nil)
+	replayed := readAll(t, replayer, query)
+	assert.Equal(t, schema, mustSchema(t, replayer, query))
+	assert.Len(t, replayed, 2)
+	assert.Equal(t, rows[0][:3], replayed[0][:3])
+	assert.Equal(t, 0, big.NewRat(21, 2).Cmp(replayed[0][3].(*big.Rat)))
+	assert.Equal(t, rows[0][4:], replayed[0][4:])
+	assert.Equal(t, rows[1], replayed[1])
+
+	// Other parameters make another query.
+	other := query
+	other.Parameters = []bigquery.QueryParameter{{Name: "Owner", Value: "03160081000185"}}
+	assert.NotEqual(t, Fingerprint(query), Fingerprint(other))
+	_, err = replayer.Query(ctx, other, bigqueryutil.JobConfig{})
+	assert.ErrorContains(t, err, "wasn't recorded")
+}
+
+func TestFingerprint(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	sao := time.FixedZone("BRT", -3*60*60)
+
+	query := func(params ...bigquery.QueryParameter) bigqueryutil.BuiltQuery {
+		return bigqueryutil.BuiltQuery{SQL: "SELECT * FROM `documents.nfe` WHERE CreatedAt > @From", Parameters: params}
+	}
+	tests := []struct {
+		name      string
+		a, b      bigqueryutil.BuiltQuery
+		wantEqual bool
+	}{
+		{
+			name:      "monotonic clock reading",
+			a:         query(bigquery.QueryParameter{Name: "From", Value: now}),
+			b:         query(bigquery.QueryParameter{Name: "From", Value: now.Round(0)}),
+			wantEqual: true,
+		},
+		{
+			name:      "time location",
+			a:         query(bigquery.QueryParameter{Name: "From", Value: now}),
+			b:         query(bigquery.QueryParameter{Name: "From", Value: now.In(sao)}),
+			wantEqual: true,
+		},
+		{
+			name: "named parameters order",
+			a: query(
+				bigquery.QueryParameter{Name: "From", Value: now},
+				bigquery.QueryParameter{Name: "Owners", Value: []string{"19427033000140"}},
+			),
+			b: query(
+				bigquery.QueryParameter{Name: "Owners", Value: []string{"19427033000140"}},
+				bigquery.QueryParameter{Name: "From", Value: now},
+			),
+			wantEqual: true,
+		},
+		{
+			name:      "equal rationals",
+			a:         query(bigquery.QueryParameter{Name: "Total", Value: big.NewRat(21, 2)}),
+			b:         query(bigquery.QueryParameter{Name: "Total", Value: big.NewRat(42, 4)}),
+			wantEqual: true,
+		},
+		{
+			name: "positional parameters order",
+			a:    query(bigquery.QueryParameter{Value: "a"}, bigquery.QueryParameter{Value: "b"}),
+			b:    query(bigquery.QueryParameter{Value: "b"}, bigquery.QueryParameter{Value: "a"}),
+		},
+		{
+			name: "other time",
+			a:    query(bigquery.QueryParameter{Name: "From", Value: now}),
+			b:    query(bigquery.QueryParameter{Name: "From", Value: now.Add(time.Nanosecond)}),
+		},
+		{
+			name: "other type",
+			a:    query(bigquery.QueryParameter{Name: "From", Value: civil.DateOf(now)}),
+			b:    query(bigquery.QueryParameter{Name: "From", Value: civil.DateOf(now).String()}),
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			if test.wantEqual {
+				assert.Equal(t, Fingerprint(test.a), Fingerprint(test.b))
+			} else {
+				assert.NotEqual(t, Fingerprint(test.a), Fingerprint(test.b))
+			}
+		})
+	}
+}
+
+func TestRecorderModeFromEnv(t *testing.T) {
+	t.Setenv("BQTEST_RECORD", "true")
+	assert.Equal(t, Record, RecorderModeFromEnv("BQTEST_RECORD"))
+	t.Setenv("BQTEST_RECORD", "")
+	assert.Equal(t, Replay, RecorderModeFromEnv("BQTEST_RECORD"))
+}
+
+func mustSchema(t *testing.T, querier bigqueryutil.Querier, q bigqueryutil.BuiltQuery) bigquery.Schema {
+	t.Helper()
+	it, err := querier.Query(context.Background(), q, bigqueryutil.JobConfig{})
+	require.NoError(t, err)
+	defer it.Close()
+	return it.Schema()
+}
+
+func readAll(t *testing.T, querier bigqueryutil.Querier, q bigqueryutil.BuiltQuery) [][]bigquery.Value {
+	t.Helper()
+	var rows [][]bigquery.Value
+	for row, err := range bigqueryutil.Query[[]bigquery.Value](context.Background(), querier, q) {
+		require.NoError(t, err)
+		rows = append(rows, row)
+	}
+	return rows
+}
@@ -0,0 +1,569 @@
+package bqtest
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/arquivei/bigqueryutil"
+	"github.com/arquivei/foundationkit/errors"
+	bq "google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/option"
+)
+
+// Server is a local stand-in for the BigQuery v2 REST API, for integration tests without network.
+// A real *bigquery.Client, returned by Client, runs queries against it.
+//
+// It implements the subset of the API used to run queries and read their results: jobs.insert,
+// jobs.query, jobs.get, jobs.cancel, jobs.getQueryResults, tables.get and tabledata.list.
+//
+// The server doesn't evaluate SQL. Queries are answered by the results registered with On, as
+// with the fake Querier, or, when none matches, by the rows of the first fixture table the query
+// references, quoted with backticks, like `project.dataset.table`. The queries are recorded with the parameters' values decoded from the request, so
+// STRING, INT64, FLOAT64 and BOOL values have their Go types and the others are strings.
+type Server struct {
+	querier *Querier
+
+	srv *httptest.Server
+
+	mu      sync.Mutex
+	tables  map[bigqueryutil.TableRef]Result
+	jobs    map[string]*serverJob
+	nextJob int
+}
+
+type serverJob struct {
+	job    *bq.Job
+	result Result
+}
+
+// NewServer starts a server. It must be closed when the test ends.
+func NewServer() *Server {
+	s := &Server{
+		querier: NewQuerier(),
+		tables:  map[bigqueryutil.TableRef]Result{},
+		jobs:    map[string]*serverJob{},
+	}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// On registers the result of the queries matched by the matcher, as Querier.On does.
+func (s *Server) On(m Matcher, r Result) *Server {
+	s.querier.On(m, r)
+	return s
+}
+
+// Queries returns the queries received so far.
+func (s *Server) Queries() []RecordedQuery {
+	return s.querier.Queries()
+}
+
+// AssertQueryCount checks that the server received n queries.
+func (s *Server) AssertQueryCount(t TestingT, n int) bool {
+	t.Helper()
+	return s.querier.AssertQueryCount(t, n)
+}
+
+// AssertQueryContains checks that the SQL of some received query contains substr.
+func (s *Server) AssertQueryContains(t TestingT, substr string) bool {
+	t.Helper()
+	return s.querier.AssertQueryContains(t, substr)
+}
+
+// AssertParam checks that some received query has the parameter with the value.
+func (s *Server) AssertParam(t TestingT, name string, value interface{}) bool {
+	t.Helper()
+	return s.querier.AssertParam(t, name, value)
+}
+
+// URL returns the endpoint of the server.
+func (s *Server) URL() string {
+	return s.srv.URL + "/bigquery/v2/"
+}
+
+// Close shuts down the server.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Client returns a client of the project that sends its requests to the server.
+func (s *Server) Client(ctx context.Context, projectID string) (*bigquery.Client, error) {
+	return bigquery.NewClient(ctx, projectID, option.WithEndpoint(s.URL()), option.WithoutAuthentication())
+}
+
+// AddTable adds a fixture table, read by tabledata.list and by the queries that read from it.
+// A table without project is found in any project, so queries may reference it as
+// `dataset.table` or `project.dataset.table`.
+func (s *Server) AddTable(ref bigqueryutil.TableRef, r Result) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tables[ref] = r
+}
+
+func (s *Server) table(ref bigqueryutil.TableRef) (Result, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r, ok := s.tables[ref]; ok {
+		return r, true
+	}
+	r, ok := s.tables[bigqueryutil.TableRef{Dataset: ref.Dataset, Table: ref.Table}]
+	return r, ok
+}
+
+// queryResult returns the result of the query: a registered result or the fixture table it reads from.
+// The fixture table is the first table referenced by the query, quoted with backticks, that was added.
+func (s *Server) queryResult(q bigqueryutil.BuiltQuery, cfg bigqueryutil.JobConfig) (Result, error) {
+	if r, ok := s.querier.result(q, cfg); ok {
+		return r, r.Err
+	}
+
+	for _, ref := range quotedTableRefs(q.SQL) {
+		if t, ok := s.table(ref); ok {
+			return t, nil
+		}
+	}
+	return Result{}, errors.Errorf("%w: %s", ErrNoMatch, q.SQL)
+}
+
+// quotedTableRefs returns the table references quoted with backticks in the SQL, like
+// `project.dataset.table` or `dataset.table`, in the order they appear.
+func quotedTableRefs(sql string) []bigqueryutil.TableRef {
+	parts := strings.Split(sql, "`")
+	refs := make([]bigqueryutil.TableRef, 0, len(parts)/2)
+	for i := 1; i < len(parts); i += 2 {
+		if ref, err := bigqueryutil.ParseTableRef(parts[i]); err == nil {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/bigquery/v2"), "/")
+	parts := strings.Split(path, "/")
+	if len(parts) < 3 || parts[0] != "projects" {
+		writeError(w, http.StatusNotFound, "notFound", "unknown path "+r.URL.Path)
+		return
+	}
+	project := parts[1]
+
+	switch {
+	case r.Method == http.MethodPost && len(parts) == 3 && parts[2] == "jobs":
+		s.insertJob(w, r, project)
+	case r.Method == http.MethodPost && len(parts) == 3 && parts[2] == "queries":
+		s.query(w, r, project)
+	case r.Method == http.MethodGet && len(parts) == 4 && parts[2] == "jobs":
+		s.getJob(w, parts[3])
+	case r.Method == http.MethodPost && len(parts) == 5 && parts[2] == "jobs" && parts[4] == "cancel":
+		s.cancelJob(w, parts[3])
+	case r.Method == http.MethodGet && len(parts) == 4 && parts[2] == "queries":
+		s.getQueryResults(w, r, parts[3])
+	case r.Method == http.MethodGet && len(parts) >= 6 && parts[2] == "datasets" && parts[4] == "tables":
+		ref := bigqueryutil.TableRef{Project: project, Dataset: parts[3], Table: parts[5]}
+		t, ok := s.table(ref)
+		if !ok {
+			writeError(w, http.StatusNotFound, "notFound", "Not found: Table "+ref.String())
+			return
+		}
+		switch {
+		case len(parts) == 6:
+			writeJSON(w, &bq.Table{
+				Kind:             "bigquery#table",
+				TableReference:   &bq.TableReference{ProjectId: project, DatasetId: ref.Dataset, TableId: ref.Table},
+				Schema:           schemaToBQ(t.Schema),
+				NumRows:          uint64(len(t.Rows)),
+				CreationTime:     time.Now().UnixMilli(),
+				LastModifiedTime: uint64(time.Now().UnixMilli()),
+			})
+		case len(parts) == 7 && parts[6] == "data":
+			rows, token, err := page(r, t)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "invalid", err.Error())
+				return
+			}
+			writeJSON(w, &bq.TableDataList{Kind: "bigquery#tableDataList", Rows: rows, PageToken: token, TotalRows: int64(len(t.Rows))})
+		default:
+			writeError(w, http.StatusNotFound, "notFound", "unknown path "+r.URL.Path)
+		}
+	default:
+		writeError(w, http.StatusNotFound, "notFound", "unknown path "+r.URL.Path)
+	}
+}
+
+func (s *Server) insertJob(w http.ResponseWriter, r *http.Request, project string) {
+	var job bq.Job
+	if err := json.NewDecoder(r.Body).Decode(&job); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid", err.Error())
+		return
+	}
+	if job.Configuration == nil || job.Configuration.Query == nil {
+		writeError(w, http.StatusBadRequest, "invalid", "only query jobs are supported")
+		return
+	}
+	if job.JobReference == nil {
+		job.JobReference = &bq.JobReference{}
+	}
+	job.JobReference.ProjectId = project
+	if job.JobReference.JobId == "" {
+		job.JobReference.JobId = s.newJobID()
+	}
+
+	q := job.Configuration.Query
+	cfg := bigqueryutil.JobConfig{
+		Labels:         job.Configuration.Labels,
+		Location:       job.JobReference.Location,
+		MaxBytesBilled: q.MaximumBytesBilled,
+		Priority:       bigquery.QueryPriority(q.Priority),
+	}
+	result, err := s.queryResult(builtQuery(q.Query, q.QueryParameters), cfg)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalidQuery", err.Error())
+		return
+	}
+
+	job.Kind = "bigquery#job"
+	job.Id = project + ":" + job.JobReference.JobId
+	job.Status = &bq.JobStatus{State: "DONE"}
+	job.Statistics = &bq.JobStatistics{
+		CreationTime: time.Now().UnixMilli(),
+		StartTime:    time.Now().UnixMilli(),
+		EndTime:      time.Now().UnixMilli(),
+		Query:        &bq.JobStatistics2{},
+	}
+	s.storeJob(&serverJob{job: &job, result: result})
+	writeJSON(w, &job)
+}
+
+func (s *Server) query(w http.ResponseWriter, r *http.Request, project string) {
+	var req bq.QueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid", err.Error())
+		return
+	}
+	cfg := bigqueryutil.JobConfig{
+		Labels:         req.Labels,
+		Location:       req.Location,
+		MaxBytesBilled: req.MaximumBytesBilled,
+	}
+	result, err := s.queryResult(builtQuery(req.Query, req.QueryParameters), cfg)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalidQuery", err.Error())
+		return
+	}
+
+	ref := &bq.JobReference{ProjectId: project, JobId: s.newJobID(), Location: req.Location}
+	s.storeJob(&serverJob{
+		job: &bq.Job{
+			Kind:          "bigquery#job",
+			Id:            project + ":" + ref.JobId,
+			JobReference:  ref,
+			Configuration: &bq.JobConfiguration{Query: &bq.JobConfigurationQuery{Query: req.Query}},
+			Status:        &bq.JobStatus{State: "DONE"},
+		},
+		result: result,
+	})
+
+	rows, err := encodeRows(result.Schema, result.Rows)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "internalError", err.Error())
+		return
+	}
+	writeJSON(w, &bq.QueryResponse{
+		Kind:         "bigquery#queryResponse",
+		JobReference: ref,
+		JobComplete:  true,
+		Schema:       schemaToBQ(result.Schema),
+		Rows:         rows,
+		TotalRows:    uint64(len(result.Rows)),
+	})
+}
+
+func (s *Server) getJob(w http.ResponseWriter, jobID string) {
+	j, ok := s.job(jobID)
+	if !ok {
+		writeError(w, http.StatusNotFound, "notFound", "Not found: Job "+jobID)
+		return
+	}
+	writeJSON(w, j.job)
+}
+
+func (s *Server) cancelJob(w http.ResponseWriter, jobID string) {
+	j, ok := s.job(jobID)
+	if !ok {
+		writeError(w, http.StatusNotFound, "notFound", "Not found: Job "+jobID)
+		return
+	}
+	writeJSON(w, &bq.JobCancelResponse{Kind: "bigquery#jobCancelResponse", Job: j.job})
+}
+
+func (s *Server) getQueryResults(w http.ResponseWriter, r *http.Request, jobID string) {
+	j, ok := s.job(jobID)
+	if !ok {
+		writeError(w, http.StatusNotFound, "notFound", "Not found: Job "+jobID)
+		return
+	}
+	rows, token, err := page(r, j.result)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid", err.Error())
+		return
+	}
+	writeJSON(w, &bq.GetQueryResultsResponse{
+		Kind:         "bigquery#getQueryResultsResponse",
+		JobReference: j.job.JobReference,
+		JobComplete:  true,
+		Schema:       schemaToBQ(j.result.Schema),
+		Rows:         rows,
+		PageToken:    token,
+		TotalRows:    uint64(len(j.result.Rows)),
+	})
+}
+
+func (s *Server) newJobID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextJob++
+	return "bqtest_job_" + strconv.Itoa(s.nextJob)
+}
+
+func (s *Server) storeJob(j *serverJob) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[j.job.JobReference.JobId] = j
+}
+
+func (s *Server) job(jobID string) (*serverJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[jobID]
+	return j, ok
+}
+
+// page returns the page of rows requested by the startIndex, maxResults and pageToken
+// parameters, and the token of the next page. The token is the index of the next row.
+func page(r *http.Request, result Result) ([]*bq.TableRow, string, error) {
+	query := r.URL.Query()
+	start := 0
+	if v := query.Get("pageToken"); v != "" {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, "", errors.New("invalid page token " + v)
+		}
+		start = i
+	} else if v := query.Get("startIndex"); v != "" {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, "", errors.New("invalid start index " + v)
+		}
+		start = i
+	}
+	if start > len(result.Rows) {
+		start = len(result.Rows)
+	}
+	end := len(result.Rows)
+	if v := query.Get("maxResults"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, "", errors.New("invalid max results " + v)
+		}
+		if start+n < end {
+			end = start + n
+		}
+	}
+
+	rows, err := encodeRows(result.Schema, result.Rows[start:end])
+	if err != nil {
+		return nil, "", err
+	}
+	token := ""
+	if end < len(result.Rows) {
+		token = strconv.Itoa(end)
+	}
+	return rows, token, nil
+}
+
+// builtQuery returns the query with the parameters decoded from the request.
+func builtQuery(sql string, params []*bq.QueryParameter) bigqueryutil.BuiltQuery {
+	q := bigqueryutil.BuiltQuery{SQL: sql}
+	for _, p := range params {
+		q.Parameters = append(q.Parameters, bigquery.QueryParameter{
+			Name:  p.Name,
+			Value: parameterValue(p.ParameterType, p.ParameterValue),
+		})
+	}
+	return q
+}
+
+func parameterValue(t *bq.QueryParameterType, v *bq.QueryParameterValue) interface{} {
+	if t == nil || v == nil {
+		return nil
+	}
+	if t.Type == "ARRAY" {
+		values := make([]interface{}, len(v.ArrayValues))
+		for i, e := range v.ArrayValues {
+			values[i] = parameterValue(t.ArrayType, e)
+		}
+		return values
+	}
+	switch t.Type {
+	case "INT64":
+		if i, err := strconv.ParseInt(v.Value, 10, 64); err == nil {
+			return i
+		}
+	case "FLOAT64":
+		if f, err := strconv.ParseFloat(v.Value, 64); err == nil {
+			return f
+		}
+	case "BOOL":
+		if b, err := strconv.ParseBool(v.Value); err == nil {
+			return b
+		}
+	}
+	return v.Value
+}
+
+func schemaToBQ(schema bigquery.Schema) *bq.TableSchema {
+	if schema == nil {
+		return nil
+	}
+	return &bq.TableSchema{Fields: fieldsToBQ(schema)}
+}
+
+func fieldsToBQ(schema bigquery.Schema) []*bq.TableFieldSchema {
+	fields := make([]*bq.TableFieldSchema, len(schema))
+	for i, f := range schema {
+		mode := "NULLABLE"
+		if f.Repeated {
+			mode = "REPEATED"
+		} else if f.Required {
+			mode = "REQUIRED"
+		}
+		fields[i] = &bq.TableFieldSchema{
+			Name:        f.Name,
+			Type:        string(f.Type),
+			Mode:        mode,
+			Description: f.Description,
+			Fields:      fieldsToBQ(f.Schema),
+		}
+	}
+	return fields
+}
+
+func encodeRows(schema bigquery.Schema, rows [][]bigquery.Value) ([]*bq.TableRow, error) {
+	out := make([]*bq.TableRow, len(rows))
+	for i, row := range rows {
+		r, err := encodeRecord(schema, row)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = r
+	}
+	return out, nil
+}
+
+func encodeRecord(schema bigquery.Schema, values []bigquery.Value) (*bq.TableRow, error) {
+	if len(values) != len(schema) {
+		return nil, errors.Errorf("bqtest: row has %d values, but the schema has %d columns", len(values), len(schema))
+	}
+	row := &bq.TableRow{F: make([]*bq.TableCell, len(values))}
+	for i, v := range values {
+		cell, err := encodeValue(schema[i], schema[i].Repeated, v)
+		if err != nil {
+			return nil, err
+		}
+		row.F[i] = &bq.TableCell{V: cell}
+	}
+	return row, nil
+}
+
+// encodeValue encodes the value as the API does: basic values are strings, records are
+// rows and repeated values are lists of cells.
+func encodeValue(f *bigquery.FieldSchema, repeated bool, v bigquery.Value) (interface{}, error) {
+	if v == nil {
+		if repeated {
+			return []interface{}{}, nil
+		}
+		return nil, nil
+	}
+
+	if repeated {
+		elems, ok := v.([]bigquery.Value)
+		if !ok {
+			return nil, errors.Errorf("bqtest: repeated column %s should be a []bigquery.Value, got %T", f.Name, v)
+		}
+		cells := make([]interface{}, len(elems))
+		for i, e := range elems {
+			cell, err := encodeValue(f, false, e)
+			if err != nil {
+				return nil, err
+			}
+			cells[i] = map[string]interface{}{"v": cell}
+		}
+		return cells, nil
+	}
+
+	if f.Type == bigquery.RecordFieldType {
+		values, ok := v.([]bigquery.Value)
+		if !ok {
+			return nil, errors.Errorf("bqtest: record %s should be a []bigquery.Value, got %T", f.Name, v)
+		}
+		return encodeRecord(f.Schema, values)
+	}
+
+	switch x := v.(type) {
+	case string:
+		return x, nil
+	case []byte:
+		return base64.StdEncoding.EncodeToString(x), nil
+	case bool:
+		return strconv.FormatBool(x), nil
+	case time.Time:
+		return strconv.FormatInt(x.UnixMicro(), 10), nil
+	case *big.Rat:
+		if f.Type == bigquery.BigNumericFieldType {
+			return bigquery.BigNumericString(x), nil
+		}
+		return bigquery.NumericString(x), nil
+	case interface{ String() string }:
+		return x.String(), nil
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(rv.Float(), 'g', -1, 64), nil
+	}
+	return nil, errors.Errorf("bqtest: unsupported value %T of column %s", v, f.Name)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, reason, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    code,
+			"message": message,
+			"errors": []map[string]interface{}{
+				{"reason": reason, "message": message},
+			},
+		},
+	})
+}
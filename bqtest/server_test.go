@@ -0,0 +1,225 @@
+package bqtest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/arquivei/bigqueryutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+type serverDocument struct {
+	AccessKey string
+	Emitter   *emitter `bigquery:"emit"`
+	Items     []int64
+	Total     float64
+	CreatedAt time.Time
+	Owner     string
+}
+
+var serverSchema = bigquery.Schema{
+	{Name: "AccessKey", Type: bigquery.StringFieldType},
+	{Name: "emit", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+		{Name: "CNPJ", Type: bigquery.StringFieldType},
+		{Name: "xNome", Type: bigquery.StringFieldType},
+	}},
+	{Name: "Items", Type: bigquery.IntegerFieldType, Repeated: true},
+	{Name: "Total", Type: bigquery.FloatFieldType},
+	{Name: "CreatedAt", Type: bigquery.TimestampFieldType},
+}
+
+var createdAt = time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+
+var serverRows = [][]bigquery.Value{
+	{"a", []bigquery.Value{"19427033000140", "Arquivei"}, []bigquery.Value{int64(1), int64(2)}, 10.5, createdAt},
+	{"b", nil, nil, 3.0, createdAt},
+	{"c", nil, []bigquery.Value{int64(3)}, 0.0, createdAt},
+}
+
+func TestServer(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	server := NewServer()
+	defer server.Close()
+	server.On(SQLContains("FROM `my-project.documents.nfe`"), Result{Schema: serverSchema, Rows: serverRows})
+
+	client, err := server.Client(ctx, "my-project")
+	require.NoError(t, err)
+	defer client.Close()
+
+	builder, err := bigqueryutil.NewBuilder(
+		bigqueryutil.QueryBuilderSpec{SQLQuery: "SELECT %s FROM %s WHERE %s"},
+		bigqueryutil.TableRef{Project: "my-project", Dataset: "documents", Table: "nfe"},
+	)
+	require.NoError(t, err)
+	query, err := builder.Build(ctx, bigqueryutil.QueryRequest{
+		Filter: struct {
+			Owners []string `bq:"Owner"`
+		}{Owners: []string{"19427033000140", "03160081000185"}},
+	})
+	require.NoError(t, err)
+
+	querier := bigqueryutil.NewClientQuerier(client, bigqueryutil.JobConfig{Labels: map[string]string{"team": "documents"}})
+	var documents []serverDocument
+	for d, err := range bigqueryutil.QueryWithConfig[serverDocument](ctx, querier, query, bigqueryutil.JobConfig{
+		Location:       "US",
+		MaxBytesBilled: 1 << 30,
+	}) {
+		require.NoError(t, err)
+		documents = append(documents, d)
+	}
+	assert.Equal(t, []serverDocument{
+		{
+			AccessKey: "a",
+			Emitter:   &emitter{CNPJ: "19427033000140", Name: "Arquivei"},
+			Items:     []int64{1, 2},
+			Total:     10.5,
+			CreatedAt: createdAt,
+		},
+		{AccessKey: "b", Total: 3, CreatedAt: createdAt},
+		{AccessKey: "c", Items: []int64{3}, CreatedAt: createdAt},
+	}, documents)
+
+	server.AssertQueryCount(t, 1)
+	server.AssertQueryContains(t, "SELECT * FROM `my-project.documents.nfe` WHERE Owner IN (@Owner0,@Owner1)")
+	server.AssertParam(t, "Owner1", "03160081000185")
+	assert.Equal(t, bigqueryutil.JobConfig{
+		Labels:         map[string]string{"team": "documents"},
+		Location:       "US",
+		MaxBytesBilled: 1 << 30,
+	}, server.Queries()[0].Config)
+
+	// Queries without a registered result fail.
+	it, err := querier.Query(ctx, bigqueryutil.BuiltQuery{SQL: "SELECT * FROM `my-project.documents.cte`"}, bigqueryutil.JobConfig{})
+	assert.Nil(t, it)
+	assert.ErrorContains(t, err, "no result matches the query")
+}
+
+func TestServerFixtureTables(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	server := NewServer()
+	defer server.Close()
+	server.AddTable(bigqueryutil.TableRef{Dataset: "documents", Table: "nfe"}, Result{Schema: serverSchema, Rows: serverRows})
+
+	client, err := server.Client(ctx, "my-project")
+	require.NoError(t, err)
+	defer client.Close()
+
+	// Queries read the fixture table, using the fast query path.
+	it, err := client.Query("SELECT * FROM `my-project.documents.nfe`").Read(ctx)
+	require.NoError(t, err)
+	var keys []string
+	for {
+		var row map[string]bigquery.Value
+		err := it.Next(&row)
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		require.NoError(t, err)
+		keys = append(keys, row["AccessKey"].(string))
+	}
+	assert.Equal(t, []string{"a", "b", "c"}, keys)
+
+	// Tables are read page by page.
+	it = client.Dataset("documents").Table("nfe").Read(ctx)
+	it.PageInfo().MaxSize = 2
+	keys = nil
+	for {
+		var row []bigquery.Value
+		err := it.Next(&row)
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		require.NoError(t, err)
+		keys = append(keys, row[0].(string))
+	}
+	assert.Equal(t, []string{"a", "b", "c"}, keys)
+	assert.Equal(t, uint64(3), it.TotalRows)
+
+	md, err := client.Dataset("documents").Table("nfe").Metadata(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, serverSchema[0].Name, md.Schema[0].Name)
+
+	_, err = client.Dataset("documents").Table("cte").Metadata(ctx)
+	assert.Error(t, err)
+
+	// Queries read the first fixture table they reference, by its whole reference.
+	server.AddTable(bigqueryutil.TableRef{Project: "my-project", Dataset: "documents", Table: "cte"}, Result{
+		Schema: serverSchema[:1],
+		Rows:   [][]bigquery.Value{{"d"}},
+	})
+	querier := bigqueryutil.NewClientQuerier(client, bigqueryutil.JobConfig{})
+	firstKey := func(sql string) (bigquery.Value, error) {
+		it, err := querier.Query(ctx, bigqueryutil.BuiltQuery{SQL: sql}, bigqueryutil.JobConfig{})
+		if err != nil {
+			return nil, err
+		}
+		defer it.Close()
+		var row []bigquery.Value
+		if err := it.Next(&row); err != nil {
+			return nil, err
+		}
+		return row[0], nil
+	}
+	key, err := firstKey("SELECT AccessKey FROM `my-project.documents.cte` JOIN `documents.nfe` USING (AccessKey)")
+	require.NoError(t, err)
+	assert.Equal(t, "d", key)
+	// The cte table is only found in its project.
+	key, err = firstKey("SELECT AccessKey FROM `documents.cte` JOIN `documents.nfe` USING (AccessKey)")
+	require.NoError(t, err)
+	assert.Equal(t, "a", key)
+	_, err = firstKey("SELECT AccessKey FROM `my-project.otherdocuments.nfe`")
+	assert.ErrorContains(t, err, "no result matches the query")
+
+	// The server is not a querier, so queries can't skip the client.
+	_, ok := interface{}(server).(bigqueryutil.Querier)
+	assert.False(t, ok)
+}
+
+func TestClientQuerierCloseDoesNotCancelJobs(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	server := NewServer()
+	defer server.Close()
+	server.On(Any(), Result{Schema: serverSchema, Rows: serverRows})
+
+	var cancels atomic.Int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/cancel") {
+			cancels.Add(1)
+		}
+		server.serveHTTP(w, r)
+	}))
+	defer proxy.Close()
+
+	client, err := bigquery.NewClient(ctx, "my-project",
+		option.WithEndpoint(proxy.URL+"/bigquery/v2/"), option.WithoutAuthentication())
+	require.NoError(t, err)
+	defer client.Close()
+	querier := bigqueryutil.NewClientQuerier(client, bigqueryutil.JobConfig{})
+	query := bigqueryutil.BuiltQuery{SQL: "SELECT * FROM `my-project.documents.nfe`"}
+
+	// The job is done once the querier returns the iterator, so closing it, before or after
+	// reading the rows, has no job to cancel.
+	it, err := querier.Query(ctx, query, bigqueryutil.JobConfig{})
+	require.NoError(t, err)
+	var row []bigquery.Value
+	require.NoError(t, it.Next(&row))
+	require.NoError(t, it.Close())
+
+	it, err = querier.Query(ctx, query, bigqueryutil.JobConfig{})
+	require.NoError(t, err)
+	require.NoError(t, it.Close())
+	assert.Equal(t, int32(0), cancels.Load())
+}
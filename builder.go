@@ -0,0 +1,152 @@
+package bigqueryutil
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/arquivei/foundationkit/errors"
+)
+
+// ErrInvalidQueryRequest is returned when a query request can't be built with the spec.
+var ErrInvalidQueryRequest = errors.New("invalid query request")
+
+// Builder builds the queries of a table, gluing the columns clause, the where clause
+// and the spec's query template together.
+type Builder struct {
+	spec  QueryBuilderSpec
+	table TableRef
+	// tmpl is the spec's parsed query template, or nil for legacy SQLQuery specs
+	tmpl *QueryTemplate
+}
+
+// NewBuilder returns a builder of queries of the table with the spec.
+func NewBuilder(spec QueryBuilderSpec, table TableRef) (*Builder, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	if !spec.hasQuery() {
+		return nil, errors.Errorf("%w: one of SQLQuery, QueryTemplate and Dedup must be set", ErrInvalidSpec)
+	}
+	if err := table.Validate(); err != nil {
+		return nil, err
+	}
+	b := &Builder{spec: spec, table: table}
+	if spec.queryTemplateText() != "" {
+		tmpl, err := spec.parsedQueryTemplate()
+		if err != nil {
+			return nil, err
+		}
+		b.tmpl = tmpl
+	}
+	return b, nil
+}
+
+// Spec returns the builder's spec.
+func (b *Builder) Spec() QueryBuilderSpec {
+	return b.spec
+}
+
+// Table returns the builder's table.
+func (b *Builder) Table() TableRef {
+	return b.table
+}
+
+// Build returns the SQL and the parameters of the request.
+//
+// The where clause holds the spec's required predicates and the filter, as returned by
+// EncodeWhereClauseForSpec. An empty where clause is rendered as TRUE, so templates like
+// "... WHERE {{.Where}}" or "... WHERE %s" stay valid.
+func (b *Builder) Build(ctx context.Context, req QueryRequest) (BuiltQuery, error) {
+	values := QueryTemplateValues{Table: b.table.String()}
+
+	columns, columnsParams, err := BuildColumnsClauseWithOptions(b.spec, req.Projection, req.Options)
+	if err != nil {
+		return BuiltQuery{}, err
+	}
+	values.Columns = columns
+
+	where, whereParams, err := EncodeWhereClauseForSpec(ctx, b.spec, req.Filter)
+	if err != nil {
+		return BuiltQuery{}, err
+	}
+	if where == "" {
+		where = "TRUE"
+	}
+	values.Where = where
+
+	if values.OrderBy, err = b.orderBy(req.OrderBy); err != nil {
+		return BuiltQuery{}, err
+	}
+	if values.Limit, err = b.limit(req.Limit); err != nil {
+		return BuiltQuery{}, err
+	}
+
+	sql, err := b.render(values)
+	if err != nil {
+		return BuiltQuery{}, err
+	}
+	return BuiltQuery{
+		SQL:        sql,
+		Parameters: append(columnsParams, whereParams...),
+	}, nil
+}
+
+// Query is like Build, but returns the client's query with the SQL and the parameters.
+func (b *Builder) Query(ctx context.Context, client *bigquery.Client, req QueryRequest) (*bigquery.Query, error) {
+	q, err := b.Build(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return q.Query(client), nil
+}
+
+// orderBy returns the value of the OrderBy slot.
+func (b *Builder) orderBy(orderBy []OrderBy) (string, error) {
+	if len(orderBy) == 0 {
+		return "", nil
+	}
+	if !b.usesSlot("OrderBy") {
+		return "", errors.Errorf("%w: the spec's query has no {{.OrderBy}} slot", ErrInvalidQueryRequest)
+	}
+	sb := strings.Builder{}
+	for i, o := range orderBy {
+		if !isValidColumnPath(o.Column) {
+			return "", errors.Errorf("%w: invalid order by column %q", ErrInvalidQueryRequest, o.Column)
+		}
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString(o.String())
+	}
+	return sb.String(), nil
+}
+
+// limit returns the value of the Limit slot.
+func (b *Builder) limit(limit int) (string, error) {
+	if limit < 0 {
+		return "", errors.Errorf("%w: negative limit %d", ErrInvalidQueryRequest, limit)
+	}
+	if limit == 0 {
+		return "", nil
+	}
+	if !b.usesSlot("Limit") {
+		return "", errors.Errorf("%w: the spec's query has no {{.Limit}} slot", ErrInvalidQueryRequest)
+	}
+	return strconv.Itoa(limit), nil
+}
+
+// render returns the spec's query with the values in its slots.
+func (b *Builder) render(values QueryTemplateValues) (string, error) {
+	if b.tmpl == nil {
+		return b.spec.RenderQuery(values)
+	}
+	return b.tmpl.Execute(values)
+}
+
+// usesSlot checks if the spec's query template uses the slot. Legacy SQLQuery templates
+// have no ORDER BY or LIMIT slots.
+func (b *Builder) usesSlot(slot string) bool {
+	return b.tmpl != nil && b.tmpl.Uses(slot)
+}
@@ -0,0 +1,147 @@
+package bigqueryutil
+
+import (
+	"context"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuilderBuild(t *testing.T) {
+	t.Parallel()
+	type filter struct {
+		Namespace string   `bq:",omitempty"`
+		Owners    []string `bq:"Owner,omitempty"`
+	}
+	legacySpec := QueryBuilderSpec{
+		SQLQuery: "SELECT * EXCEPT(r) FROM (SELECT %s, " +
+			"ROW_NUMBER() OVER (PARTITION BY AccessKey, Owner order by Version desc) r " +
+			"FROM %s WHERE %s) WHERE r = 1",
+	}
+	templateSpec := QueryBuilderSpec{
+		RepeatedColumns: map[string]struct{}{"Events": {}},
+		QueryTemplate: "SELECT {{.Columns}} FROM {{.Table}} WHERE {{.Where}}" +
+			"{{with .OrderBy}} ORDER BY {{.}}{{end}}{{with .Limit}} LIMIT {{.}}{{end}}",
+		RequiredPredicates: []RequiredPredicate{
+			{Column: "Owner", Value: ContextValue(ownerContextKey{})},
+		},
+	}
+	ctx := context.WithValue(context.Background(), ownerContextKey{}, "19427033000140")
+
+	tests := []struct {
+		name       string
+		spec       QueryBuilderSpec
+		request    QueryRequest
+		wantSQL    string
+		wantParams []bigquery.QueryParameter
+		wantErr    error
+	}{
+		{
+			name: "legacy query",
+			spec: legacySpec,
+			request: QueryRequest{
+				Projection: []ProjectionField{{Path: "AccessKey"}},
+				Filter:     filter{Namespace: "tiramissu", Owners: []string{"a", "b"}},
+			},
+			wantSQL: "SELECT * EXCEPT(r) FROM (SELECT AccessKey, " +
+				"ROW_NUMBER() OVER (PARTITION BY AccessKey, Owner order by Version desc) r " +
+				"FROM `project.dataset.table` WHERE Namespace = @Namespace AND Owner IN (@Owner0,@Owner1)) WHERE r = 1",
+			wantParams: []bigquery.QueryParameter{
+				{Name: "Namespace", Value: "tiramissu"},
+				{Name: "Owner0", Value: "a"},
+				{Name: "Owner1", Value: "b"},
+			},
+		},
+		{
+			name: "legacy query with empty where",
+			spec: legacySpec,
+			request: QueryRequest{
+				Filter: filter{},
+			},
+			wantSQL: "SELECT * EXCEPT(r) FROM (SELECT *, " +
+				"ROW_NUMBER() OVER (PARTITION BY AccessKey, Owner order by Version desc) r " +
+				"FROM `project.dataset.table` WHERE TRUE) WHERE r = 1",
+		},
+		{
+			name: "query template",
+			spec: templateSpec,
+			request: QueryRequest{
+				Projection: []ProjectionField{{Path: "AccessKey"}, {Path: "Events.Date"}},
+				Filter:     filter{Namespace: "tiramissu"},
+				Options: ColumnsClauseOptions{
+					Arrays: map[string]ArrayOptions{
+						"Events": {Filter: struct{ Type string }{Type: "cancel"}},
+					},
+				},
+				OrderBy: []OrderBy{{Column: "CreatedAt", Descending: true}, {Column: "AccessKey"}},
+				Limit:   10,
+			},
+			wantSQL: "SELECT AccessKey,ARRAY(SELECT AS STRUCT Date FROM UNNEST(Events) WHERE Type = @Events_Type) AS Events " +
+				"FROM `project.dataset.table` WHERE Owner = @required_Owner AND Namespace = @Namespace " +
+				"ORDER BY CreatedAt DESC,AccessKey LIMIT 10",
+			wantParams: []bigquery.QueryParameter{
+				{Name: "Events_Type", Value: "cancel"},
+				{Name: "required_Owner", Value: "19427033000140"},
+				{Name: "Namespace", Value: "tiramissu"},
+			},
+		},
+		{
+			name:    "order by without slot",
+			spec:    legacySpec,
+			request: QueryRequest{OrderBy: []OrderBy{{Column: "CreatedAt"}}},
+			wantErr: ErrInvalidQueryRequest,
+		},
+		{
+			name:    "limit without slot",
+			spec:    legacySpec,
+			request: QueryRequest{Limit: 10},
+			wantErr: ErrInvalidQueryRequest,
+		},
+		{
+			name:    "invalid order by column",
+			spec:    templateSpec,
+			request: QueryRequest{OrderBy: []OrderBy{{Column: "CreatedAt; DROP"}}},
+			wantErr: ErrInvalidQueryRequest,
+		},
+		{
+			name: "missing required predicate",
+			spec: QueryBuilderSpec{
+				QueryTemplate:      templateSpec.QueryTemplate,
+				RequiredPredicates: []RequiredPredicate{{Column: "Tenant", Value: ContextValue("tenant")}},
+			},
+			wantErr: ErrMissingRequiredPredicate,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			builder, err := NewBuilder(test.spec, TableRef{Project: "project", Dataset: "dataset", Table: "table"})
+			assert.NoError(t, err)
+
+			query, err := builder.Build(ctx, test.request)
+			if test.wantErr != nil {
+				assert.ErrorIs(t, err, test.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.wantSQL, query.SQL)
+			assert.Equal(t, test.wantParams, query.Parameters)
+		})
+	}
+}
+
+func TestNewBuilder(t *testing.T) {
+	t.Parallel()
+	table := TableRef{Dataset: "dataset", Table: "table"}
+	_, err := NewBuilder(QueryBuilderSpec{}, table)
+	assert.ErrorIs(t, err, ErrInvalidSpec)
+
+	_, err = NewBuilder(QueryBuilderSpec{QueryTemplate: "SELECT {{.Columns}}"}, table)
+	assert.ErrorIs(t, err, ErrInvalidQueryTemplate)
+
+	_, err = NewBuilder(QueryBuilderSpec{SQLQuery: "SELECT %s FROM %s WHERE %s"}, TableRef{Table: "table"})
+	assert.ErrorIs(t, err, ErrInvalidTableRef)
+}
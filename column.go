@@ -3,22 +3,31 @@ package bigqueryutil
 import (
 	"io"
 	"strings"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/arquivei/foundationkit/errors"
 )
 
 const (
 	columnNameSegmentString = iota
 	columnNameSegmentStruct
 	columnNameSegmentArray
+	columnNameSegmentExcluded
 )
 
 // columnNameSegment holds a segment of the column name and a reference to the builder of the rest of the name.
 // If builder is nil, name should hold the full name of the column.
 type columnNameSegment struct {
-	// Type of the segment: string, array or struct
+	// Type of the segment: string, array, struct or excluded
 	_type int
 	// The name of the current segment, examples: AccessKey,imposto, det, xNome
 	name string
-	// If this segment is an array or a struct it will have children segments
+	// The name of the segment in the result, if it should differ from name
+	alias string
+	// If the column is listed in the spec's RepeatedColumns
+	repeated bool
+	// If this segment is an array or a struct it will have children segments.
+	// If this segment is a string, it may have the children excluded from it.
 	childrenBuilder *columnsClauseBuilder
 	// The parent segment of this segment
 	parent *columnNameSegment
@@ -34,7 +43,7 @@ type columnNameSegment struct {
 //	Events.Date -> Date
 //	NFe.infNFe.det.imposto.ICMS.ICMS00.vICMS -> imposto.ICMS.ICMS00.vICMS
 func (cns *columnNameSegment) fullnameInsideArray() string {
-	if cns.parent != nil && cns.parent._type != columnNameSegmentArray {
+	if cns.parent != nil && !cns.parent.repeated {
 		return cns.parent.fullnameInsideArray() + "." + cns.name
 	}
 	return cns.name
@@ -49,47 +58,294 @@ func (cns *columnNameSegment) fullname() string {
 	return cns.name
 }
 
+// outputName returns the name the segment will have in the result.
+func (cns *columnNameSegment) outputName() string {
+	if cns.alias != "" {
+		return cns.alias
+	}
+	return cns.name
+}
+
 // columnsClauseBuilder takes columns names in it's string form like "NFe.infNFe.emit.CNPJ" and builds
 // a bigquery columns clause with complex fields as structs, like "struct(struct(struct(NFe.infNFe.emit.CNPJ) AS emit) AS infNFe) AS NFe".
+//
+// Columns prefixed with a minus sign, like "-NFe.Signature", are excluded from the nearest column
+// projected as a whole. If no column was projected at all, the whole table is projected.
 type columnsClauseBuilder struct {
 	columns []*columnNameSegment
-	parent  *columnNameSegment
-	spec    QueryBuilderSpec
+	// index holds the columns by their output name
+	index  map[string]*columnNameSegment
+	parent *columnNameSegment
+	spec   QueryBuilderSpec
+	// except is true when columns lists what should be removed from the parent instead of what should be kept
+	except bool
+	// exclusions are only resolved when the clause is written so they don't depend on the projection order
+	exclusions []ProjectionField
+	// computed holds the projected computed columns, that are kept as extra columns
+	// of their parents if they are projected as a whole
+	computed []ProjectionField
+	// options are shared by all the builders of the same columns clause
+	options *columnsClauseOptions
 }
 
-// AddColumn takes a columns name.
+// newChildrenBuilder returns a builder for the children of the segment that shares
+// the spec and the options of this builder.
+func (b *columnsClauseBuilder) newChildrenBuilder(segment *columnNameSegment, except bool) *columnsClauseBuilder {
+	return &columnsClauseBuilder{
+		spec:    b.spec,
+		options: b.options,
+		parent:  segment,
+		except:  except,
+	}
+}
+
+// AddColumn takes a columns name, optionally followed by an alias like "NFe.infNFe.emit.CNPJ AS EmitterCNPJ".
 func (b *columnsClauseBuilder) AddColumn(c string) {
-	s := strings.Split(c, ".")
-	b.addColumn(s[0], s[1:])
+	b.AddField(ParseProjectionField(c))
 }
 
-// addColumn takes the parent segment, the remainder of the segment and the full name
+// AddField takes a projection field. Invalid fields and fields whose output name is
+// already taken by another column fail the columns clause and are not added, unless the
+// options are unchecked.
+func (b *columnsClauseBuilder) AddField(f ProjectionField) {
+	if err := f.Validate(); err != nil && !b.options.isUnchecked() {
+		b.options.fail(err)
+		return
+	}
+	if f.Exclude {
+		b.exclusions = append(b.exclusions, f)
+		return
+	}
+	if _, ok := b.spec.ComputedColumns[f.Path]; ok {
+		b.computed = append(b.computed, f)
+	}
+	s := strings.Split(f.Path, ".")
+	b.addColumn(s[0], s[1:], f.Alias)
+}
+
+// addColumn takes the parent segment, the remainder of the segment and the alias of the last segment,
 // parses is and append to the list of columns.
-func (b *columnsClauseBuilder) addColumn(head string, tail []string) {
+//
+// The columns are normalized while they are added: a column added twice is only projected once
+// and a column projected as a whole subsumes all of its children, regardless of the order
+// they were added. Columns are kept in the order they first appeared.
+func (b *columnsClauseBuilder) addColumn(head string, tail []string, alias string) {
 	if len(tail) == 0 {
-		b.columns = append(b.columns, newStringSegment(b.parent, head))
+		outputName := head
+		if alias != "" {
+			outputName = alias
+		}
+		segment := b.getSegment(outputName)
+		if segment != nil && segment.name == head {
+			// The column is already projected, or it is projected partially and
+			// will now be projected as a whole.
+			segment._type = columnNameSegmentString
+			segment.childrenBuilder = nil
+			return
+		}
+		if segment != nil && !b.options.isUnchecked() {
+			b.failOutputNameCollision(segment, head)
+			return
+		}
+		segment = newStringSegment(b.spec, b.parent, head)
+		segment.alias = alias
+		b.appendSegment(segment)
 		return
 	}
 
-	b.getOrCreateExistingSegment(head).
-		childrenBuilder.addColumn(tail[0], tail[1:])
+	segment := b.getSegment(head)
+	if segment != nil && segment.name != head && !b.options.isUnchecked() {
+		b.failOutputNameCollision(segment, head)
+		return
+	}
+	segment = b.getOrCreateExistingSegment(head)
+	if segment._type == columnNameSegmentString {
+		// The parent column is already projected as a whole.
+		return
+	}
+	segment.childrenBuilder.addColumn(tail[0], tail[1:], alias)
+}
+
+// failOutputNameCollision fails the columns clause because the column would have the same
+// output name as the segment, which is already projected.
+func (b *columnsClauseBuilder) failOutputNameCollision(segment *columnNameSegment, name string) {
+	path := name
+	if b.parent != nil {
+		path = b.parent.fullname() + "." + name
+	}
+	b.options.fail(errors.Errorf("%w: %s and %s are both projected as %s",
+		ErrInvalidProjection, segment.fullname(), path, segment.outputName()))
 }
 
 func (b *columnsClauseBuilder) getOrCreateExistingSegment(name string) *columnNameSegment {
 	segment := b.getSegment(name)
-	if segment == nil {
-		segment = newArrayOrStructSegment(b.spec, b.parent, name)
-		b.columns = append(b.columns, segment)
+	if segment == nil || segment.name != name {
+		segment = b.newArrayOrStructSegment(name)
+		b.appendSegment(segment)
 	}
 	return segment
 }
 
-func newStringSegment(parent *columnNameSegment, name string) *columnNameSegment {
-	return &columnNameSegment{
+// resolveExclusions removes the excluded columns from the columns projected as a whole.
+//
+// Columns denied by the spec's policies are also excluded here. Repeated columns with options,
+// JSON columns and masked columns inside columns projected as a whole are also resolved here,
+// as they must be replaced to apply the options even if nothing is excluded from them.
+// Computed columns inside columns projected as a whole are added to them as extra columns.
+func (b *columnsClauseBuilder) resolveExclusions() {
+	rebuilt := b.options.rebuiltPaths()
+	denied := b.options.deniedPaths()
+	if len(b.exclusions) == 0 && len(rebuilt) == 0 && len(denied) == 0 && len(b.computed) == 0 {
+		return
+	}
+	if len(b.columns) == 0 {
+		b.except = true
+	}
+	for _, f := range b.exclusions {
+		s := strings.Split(f.Path, ".")
+		b.exclude(s[0], s[1:], false)
+	}
+	for _, path := range denied {
+		s := strings.Split(path, ".")
+		b.exclude(s[0], s[1:], false)
+	}
+	for _, path := range rebuilt {
+		s := strings.Split(path, ".")
+		b.exclude(s[0], s[1:], true)
+	}
+	for _, f := range b.computed {
+		s := strings.Split(f.Path, ".")
+		b.keepComputed(s[0], s[1:], f.Alias)
+	}
+	b.exclusions = nil
+	b.computed = nil
+}
+
+// keepComputed walks the projected columns looking for a column projected as a whole
+// that subsumed the computed column, and adds the computed column to it. Aliased columns
+// don't subsume their children, so they are skipped.
+func (b *columnsClauseBuilder) keepComputed(head string, tail []string, alias string) {
+	if len(tail) == 0 {
+		return
+	}
+	for _, segment := range b.columns {
+		if segment.name != head {
+			continue
+		}
+		switch segment._type {
+		case columnNameSegmentString:
+			if segment.alias != "" {
+				continue
+			}
+			if segment.childrenBuilder == nil {
+				segment.childrenBuilder = b.newChildrenBuilder(segment, true)
+			}
+			segment.childrenBuilder.addComputed(tail[0], tail[1:], alias)
+		case columnNameSegmentStruct, columnNameSegmentArray:
+			segment.childrenBuilder.keepComputed(tail[0], tail[1:], alias)
+		}
+	}
+}
+
+// addComputed adds the computed column to the columns added to the parent, rebuilding
+// the columns between them. Computed columns inside excluded columns fail the columns clause.
+func (b *columnsClauseBuilder) addComputed(head string, tail []string, alias string) {
+	segment := b.getSegment(head)
+	if len(tail) == 0 {
+		if segment != nil && segment.name == head {
+			return
+		}
+		segment = newStringSegment(b.spec, b.parent, head)
+		segment.alias = alias
+		b.appendSegment(segment)
+		return
+	}
+
+	if segment == nil {
+		segment = newStringSegment(b.spec, b.parent, head)
+		b.appendSegment(segment)
+	}
+	if segment._type == columnNameSegmentExcluded {
+		b.options.fail(errors.Errorf("%w: %s.%s is computed inside the excluded column %s",
+			ErrInvalidProjection, segment.fullname(), strings.Join(tail, "."), segment.fullname()))
+		return
+	}
+	if segment.childrenBuilder == nil {
+		segment.childrenBuilder = b.newChildrenBuilder(segment, true)
+	}
+	segment.childrenBuilder.addComputed(tail[0], tail[1:], alias)
+}
+
+// exclude walks the projected columns looking for a column projected as a whole
+// to remove the given column from. Exclusions of columns that are not inside a column
+// projected as a whole are ignored, as there is nothing to remove them from.
+//
+// If rebuild is true, the column is not removed, but it is rebuilt inside its parent.
+func (b *columnsClauseBuilder) exclude(head string, tail []string, rebuild bool) {
+	if b.except {
+		b.addExclusion(head, tail, rebuild)
+		return
+	}
+	if len(tail) == 0 {
+		return
+	}
+	for _, segment := range b.columns {
+		if segment.name != head {
+			continue
+		}
+		switch segment._type {
+		case columnNameSegmentString:
+			if segment.childrenBuilder == nil {
+				segment.childrenBuilder = b.newChildrenBuilder(segment, true)
+			}
+			segment.childrenBuilder.addExclusion(tail[0], tail[1:], rebuild)
+		case columnNameSegmentStruct, columnNameSegmentArray:
+			segment.childrenBuilder.exclude(tail[0], tail[1:], rebuild)
+		}
+	}
+}
+
+// addExclusion adds the column to the list of columns removed from the parent.
+// Excluding a column also excludes all of its children.
+//
+// If rebuild is true, the column is added to the list of columns replaced in the
+// parent instead, unless it is already excluded.
+func (b *columnsClauseBuilder) addExclusion(head string, tail []string, rebuild bool) {
+	segment := b.getSegment(head)
+	if len(tail) == 0 {
+		if segment == nil {
+			segment = newStringSegment(b.spec, b.parent, head)
+			b.appendSegment(segment)
+		}
+		if !rebuild {
+			segment._type = columnNameSegmentExcluded
+			segment.childrenBuilder = nil
+		}
+		return
+	}
+
+	if segment == nil {
+		segment = newStringSegment(b.spec, b.parent, head)
+		segment.childrenBuilder = b.newChildrenBuilder(segment, true)
+		b.appendSegment(segment)
+	}
+	if segment._type == columnNameSegmentExcluded {
+		return
+	}
+	if segment.childrenBuilder == nil {
+		segment.childrenBuilder = b.newChildrenBuilder(segment, true)
+	}
+	segment.childrenBuilder.addExclusion(tail[0], tail[1:], rebuild)
+}
+
+func newStringSegment(spec QueryBuilderSpec, parent *columnNameSegment, name string) *columnNameSegment {
+	segment := &columnNameSegment{
 		_type:  columnNameSegmentString,
 		name:   name,
 		parent: parent,
 	}
+	segment.repeated = isRepeated(spec, segment.fullname())
+	return segment
 }
 
 // getArrayOrStructSegmentType will either return an array segment or a struct segment
@@ -101,52 +357,205 @@ func getArrayOrStructSegmentType(spec QueryBuilderSpec, fullname string) int {
 	return columnNameSegmentStruct
 }
 
-func newArrayOrStructSegment(spec QueryBuilderSpec, parent *columnNameSegment, name string) *columnNameSegment {
+func (b *columnsClauseBuilder) newArrayOrStructSegment(name string) *columnNameSegment {
 	segment := &columnNameSegment{
 		name:   name,
-		parent: parent,
-	}
-	segment._type = getArrayOrStructSegmentType(spec, segment.fullname())
-	segment.childrenBuilder = &columnsClauseBuilder{
-		spec:   spec,
-		parent: segment,
+		parent: b.parent,
 	}
+	segment._type = getArrayOrStructSegmentType(b.spec, segment.fullname())
+	segment.repeated = segment._type == columnNameSegmentArray
+	segment.childrenBuilder = b.newChildrenBuilder(segment, false)
 	return segment
 }
 
-func (b *columnsClauseBuilder) getSegment(name string) *columnNameSegment {
-	for i := range b.columns {
-		if b.columns[i].name == name {
-			return b.columns[i]
-		}
+// appendSegment appends the segment to the list of columns and indexes it by its output name.
+func (b *columnsClauseBuilder) appendSegment(segment *columnNameSegment) {
+	if b.index == nil {
+		b.index = make(map[string]*columnNameSegment)
 	}
-	return nil
+	b.columns = append(b.columns, segment)
+	b.index[segment.outputName()] = segment
+}
+
+// getSegment returns the segment with the given output name, if any.
+// If more than one segment has the same output name, the last one is returned.
+func (b *columnsClauseBuilder) getSegment(name string) *columnNameSegment {
+	return b.index[name]
 }
 
 // write writes all added columns to the string writer.
 func (b *columnsClauseBuilder) write(w io.StringWriter) {
+	b.resolveExclusions()
+	if b.except {
+		w.WriteString("*")
+		b.writeExcept(w)
+		return
+	}
 	for i, c := range b.columns {
 		if i > 0 {
 			w.WriteString(",")
 		}
-		switch c._type {
-		case columnNameSegmentString:
-			w.WriteString(c.fullnameInsideArray())
-		case columnNameSegmentStruct:
-			w.WriteString("STRUCT(")
-			c.childrenBuilder.write(w)
-			w.WriteString(") AS ")
-			w.WriteString(c.name)
-		case columnNameSegmentArray:
-			w.WriteString("ARRAY(SELECT AS STRUCT ")
-			c.childrenBuilder.write(w)
-			w.WriteString(" FROM UNNEST(")
-			w.WriteString(c.fullnameInsideArray())
-			w.WriteString(")) AS ")
-			w.WriteString(c.name)
-		default:
-			panic("unknown columnNameSegment")
+		b.writeSegmentExpr(w, c)
+		if !b.isPlain(c) {
+			w.WriteString(" AS ")
+			w.WriteString(c.outputName())
+		}
+	}
+}
+
+// isPlain checks if the segment is written as just a reference to the column, so it doesn't need to be named.
+func (b *columnsClauseBuilder) isPlain(c *columnNameSegment) bool {
+	return c._type == columnNameSegmentString &&
+		c.alias == "" &&
+		!b.isRebuilt(c) &&
+		!b.isComputed(c) &&
+		!b.options.isJSON(c) &&
+		b.columnAccess(c) == ColumnAllow
+}
+
+// columnAccess returns the access of the options' role to the column of the segment.
+func (b *columnsClauseBuilder) columnAccess(c *columnNameSegment) ColumnAccess {
+	if len(b.spec.ColumnPolicies) == 0 {
+		return ColumnAllow
+	}
+	if b.isComputed(c) {
+		return b.spec.computedColumnAccess(c.fullname(), b.options.roleName())
+	}
+	return b.spec.columnAccess(c.fullname(), b.options.roleName())
+}
+
+// isComputed checks if the segment is a virtual column computed by an expression of the spec.
+func (b *columnsClauseBuilder) isComputed(c *columnNameSegment) bool {
+	if c._type != columnNameSegmentString || len(b.spec.ComputedColumns) == 0 {
+		return false
+	}
+	_, ok := b.spec.ComputedColumns[c.fullname()]
+	return ok
+}
+
+// isRebuilt checks if the column of a string segment is rebuilt instead of just being referenced.
+func (b *columnsClauseBuilder) isRebuilt(c *columnNameSegment) bool {
+	return c.childrenBuilder != nil || b.options.hasArray(c)
+}
+
+// writeSegmentExpr writes the expression of a single column, without its name.
+func (b *columnsClauseBuilder) writeSegmentExpr(w io.StringWriter, c *columnNameSegment) {
+	if b.options.isJSON(c) {
+		w.WriteString("TO_JSON_STRING(")
+		defer w.WriteString(")")
+	}
+	switch c._type {
+	case columnNameSegmentString:
+		switch b.columnAccess(c) {
+		case ColumnDeny:
+			b.options.fail(errors.Errorf("%w: %s", ErrColumnDenied, c.fullname()))
+			w.WriteString("NULL")
+			return
+		case ColumnMaskNull:
+			w.WriteString("NULL")
+			return
+		case ColumnMaskSHA256:
+			// Records and arrays can't be cast to strings, so every column is hashed by its JSON.
+			w.WriteString("TO_HEX(SHA256(TO_JSON_STRING(")
+			defer w.WriteString(")))")
 		}
+		if b.isComputed(c) {
+			w.WriteString(b.spec.ComputedColumns[c.fullname()])
+			return
+		}
+		if b.isRebuilt(c) {
+			b.writeExceptSegmentExpr(w, c)
+			return
+		}
+		w.WriteString(c.fullnameInsideArray())
+	case columnNameSegmentStruct:
+		w.WriteString("STRUCT(")
+		c.childrenBuilder.write(w)
+		w.WriteString(")")
+	case columnNameSegmentArray:
+		w.WriteString("ARRAY(SELECT AS STRUCT ")
+		b.options.writeArrayOffset(w, c)
+		c.childrenBuilder.write(w)
+		w.WriteString(" FROM UNNEST(")
+		w.WriteString(c.fullnameInsideArray())
+		w.WriteString(")")
+		b.options.writeArrayClauses(w, c)
+		w.WriteString(")")
+	default:
+		panic("unknown columnNameSegment")
+	}
+}
+
+// writeExcept writes the EXCEPT and REPLACE modifiers of a column projected as a whole,
+// followed by the computed columns added to it.
+func (b *columnsClauseBuilder) writeExcept(w io.StringWriter) {
+	n := 0
+	for _, c := range b.columns {
+		if c._type != columnNameSegmentExcluded {
+			continue
+		}
+		if n == 0 {
+			w.WriteString(" EXCEPT(")
+		} else {
+			w.WriteString(",")
+		}
+		w.WriteString(c.name)
+		n++
+	}
+	if n > 0 {
+		w.WriteString(")")
+	}
+
+	n = 0
+	for _, c := range b.columns {
+		if c._type == columnNameSegmentExcluded || b.isComputed(c) {
+			continue
+		}
+		if n == 0 {
+			w.WriteString(" REPLACE(")
+		} else {
+			w.WriteString(",")
+		}
+		b.writeSegmentExpr(w, c)
+		w.WriteString(" AS ")
+		w.WriteString(c.outputName())
+		n++
+	}
+	if n > 0 {
+		w.WriteString(")")
+	}
+
+	for _, c := range b.columns {
+		if c._type == columnNameSegmentExcluded || !b.isComputed(c) {
+			continue
+		}
+		w.WriteString(",")
+		b.writeSegmentExpr(w, c)
+		w.WriteString(" AS ")
+		w.WriteString(c.outputName())
+	}
+}
+
+// writeExceptSegmentExpr writes the expression of a column projected as a whole with some of its children excluded.
+// Structs are rebuilt with (SELECT AS STRUCT x.* EXCEPT(...)) and arrays with
+// ARRAY(SELECT AS STRUCT * EXCEPT(...) FROM UNNEST(x)).
+func (b *columnsClauseBuilder) writeExceptSegmentExpr(w io.StringWriter, c *columnNameSegment) {
+	if c.repeated {
+		w.WriteString("ARRAY(SELECT AS STRUCT *")
+		if c.childrenBuilder != nil {
+			c.childrenBuilder.writeExcept(w)
+		}
+		w.WriteString(" FROM UNNEST(")
+		w.WriteString(c.fullnameInsideArray())
+		w.WriteString(")")
+		b.options.writeArrayClauses(w, c)
+		w.WriteString(")")
+	} else {
+		w.WriteString("(SELECT AS STRUCT ")
+		w.WriteString(c.fullnameInsideArray())
+		w.WriteString(".*")
+		c.childrenBuilder.writeExcept(w)
+		w.WriteString(")")
 	}
 }
 
@@ -161,19 +570,131 @@ func isRepeated(spec QueryBuilderSpec, field string) bool {
 	return ok
 }
 
+// ParseProjectionField parses a column name optionally followed by an alias,
+// like "NFe.infNFe.emit.CNPJ AS EmitterCNPJ". The AS keyword is case insensitive.
+//
+// A leading minus sign, like "-NFe.Signature", marks the column as excluded.
+//
+// The field is not validated, malformed input like "A AS" is kept as the path.
+// The columns clause builders reject invalid fields, see ProjectionField.Validate.
+func ParseProjectionField(s string) ProjectionField {
+	s = strings.TrimSpace(s)
+	if !strings.ContainsAny(s, " \t-") {
+		return ProjectionField{Path: s}
+	}
+	if strings.HasPrefix(s, "-") {
+		return ProjectionField{Path: strings.TrimSpace(s[1:]), Exclude: true}
+	}
+	parts := strings.Fields(s)
+	if len(parts) == 3 && strings.EqualFold(parts[1], "AS") {
+		return ProjectionField{Path: parts[0], Alias: parts[2]}
+	}
+	return ProjectionField{Path: s}
+}
+
 // BuildColumnsClause builds a column clause.
+//
+// The projection is normalized: duplicated columns are projected once and a column subsumes
+// all of its children, like "NFe.infNFe" and "NFe.infNFe.emit.CNPJ". Computed columns of the spec are not
+// part of the table, so they are added to the columns projected as a whole instead. Columns keep the order
+// in which they first appear in the projection.
+//
+// A column prefixed with a minus sign, like "-RawXML" or "-NFe.Signature", is excluded from the result.
+// If only exclusions are given, all the other columns of the table are projected with
+// "* EXCEPT(...)", and nested exclusions rebuild their parent structs without the excluded columns.
+// If the projection also has columns, exclusions remove nested columns from the columns projected
+// as a whole, like "NFe" and "-NFe.Signature".
+//
+// Each projected column may be followed by an alias, like "NFe.infNFe.emit.CNPJ AS EmitterCNPJ".
+// The alias renames only the last segment of the path, so the column is still returned
+// inside its parent structs and arrays.
+//
+// As errors are not reported, the projected columns and aliases are not validated: they are
+// written as given, like "`weird col`" or "Événement", and columns with the same output name are
+// all projected, leaving BigQuery to reject the query. The other columns clause builders reject
+// such projections with ErrInvalidProjection instead.
+//
+// Column policies of the spec are applied for the empty role. An empty string is returned if a
+// projected column is denied or a computed column is inside an excluded column. Use
+// BuildColumnsClauseFromFields, BuildColumnsClauseForRole or BuildColumnsClauseWithOptions to get the error instead.
 func BuildColumnsClause(spec QueryBuilderSpec, projection []string) string {
+	fields := make([]ProjectionField, len(projection))
+	for i, p := range projection {
+		fields[i] = ParseProjectionField(p)
+	}
+	options, err := compileColumnsClauseOptions(spec, ColumnsClauseOptions{})
+	if err != nil {
+		return ""
+	}
+	options.unchecked = true
+	columns, _, _ := buildColumnsClause(spec, fields, options)
+	return columns
+}
+
+// BuildColumnsClauseFromFields is like BuildColumnsClause, but takes already parsed projection fields
+// and returns an error if the projection is invalid or a projected column is denied to the empty role.
+// Projected columns and aliases must be made of letters, numbers and underscores, and can't start
+// with a number, see ProjectionField.Validate.
+func BuildColumnsClauseFromFields(spec QueryBuilderSpec, projection []ProjectionField) (string, error) {
+	columns, _, err := BuildColumnsClauseWithOptions(spec, projection, ColumnsClauseOptions{})
+	return columns, err
+}
+
+// BuildColumnsClauseWithOptions is like BuildColumnsClauseFromFields, but also applies the options.
+// It returns the parameters used by the columns clause, that must be added to the query.
+//
+// Options for repeated columns are applied wherever the repeated column is rebuilt:
+//
+//	ARRAY(SELECT AS STRUCT Date FROM UNNEST(Events) WHERE Type = @Events_Type ORDER BY Date DESC LIMIT 10) AS Events
+//
+// The parameters of a repeated column filter are prefixed by the path of the column, with
+// dots replaced by underscores, so they don't collide with the parameters of the where clause.
+func BuildColumnsClauseWithOptions(
+	spec QueryBuilderSpec,
+	projection []ProjectionField,
+	opts ColumnsClauseOptions,
+) (string, []bigquery.QueryParameter, error) {
+	options, err := compileColumnsClauseOptions(spec, opts)
+	if err != nil {
+		return "", nil, err
+	}
+	return buildColumnsClause(spec, projection, options)
+}
+
+// buildColumnsClause builds the columns clause of the projection with the compiled options.
+func buildColumnsClause(
+	spec QueryBuilderSpec,
+	projection []ProjectionField,
+	options *columnsClauseOptions,
+) (string, []bigquery.QueryParameter, error) {
 	// Sanity check.
 	// The projection fields are a required field on the HTTP API.
-	if len(projection) == 0 {
-		return "*"
+	if len(projection) == 0 && len(options.rebuiltPaths()) == 0 && len(options.denied) == 0 && !options.flat {
+		return "*", nil, nil
 	}
 
-	cb := columnsClauseBuilder{spec: spec}
+	cb := columnsClauseBuilder{spec: spec, options: options}
 
 	for _, f := range projection {
-		cb.AddColumn(f)
+		cb.AddField(f)
 	}
 
-	return cb.String()
+	if !options.flat {
+		columns := cb.String()
+		if options.err != nil {
+			return "", nil, options.err
+		}
+		return columns, options.params, nil
+	}
+
+	cb.resolveExclusions()
+	if cb.except || len(cb.columns) == 0 {
+		return "", nil, errors.New("flat mode requires the columns to be listed in the projection")
+	}
+	sb := strings.Builder{}
+	cb.writeFlat(&sb, func(expr string) string { return expr }, map[string]string{})
+	if options.err != nil {
+		return "", nil, options.err
+	}
+	return sb.String(), options.params, nil
 }
@@ -0,0 +1,156 @@
+package bigqueryutil
+
+import (
+	"container/list"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ColumnsClauseCache holds the most recently built columns clauses, so projections
+// that are requested repeatedly don't have their columns tree rebuilt on every request.
+// It is safe for concurrent use.
+type ColumnsClauseCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type columnsClauseCacheEntry struct {
+	key     string
+	columns string
+}
+
+// NewColumnsClauseCache returns a cache that holds at most size columns clauses.
+// When the cache is full, the least recently used clause is evicted.
+func NewColumnsClauseCache(size int) *ColumnsClauseCache {
+	if size < 1 {
+		size = 1
+	}
+	return &ColumnsClauseCache{
+		size:    size,
+		entries: make(map[string]*list.Element, size),
+		lru:     list.New(),
+	}
+}
+
+// BuildColumnsClause is like the package's BuildColumnsClauseFromFields, but parses the projection
+// and returns the cached clause if the same spec and projection were already built.
+func (c *ColumnsClauseCache) BuildColumnsClause(spec QueryBuilderSpec, projection []string) (string, error) {
+	fields := make([]ProjectionField, len(projection))
+	for i, p := range projection {
+		fields[i] = ParseProjectionField(p)
+	}
+	return c.BuildColumnsClauseFromFields(spec, fields)
+}
+
+// BuildColumnsClauseFromFields is like the package's BuildColumnsClauseFromFields, but returns the cached
+// clause if the same spec and projection were already built. Failures are not cached.
+func (c *ColumnsClauseCache) BuildColumnsClauseFromFields(spec QueryBuilderSpec, projection []ProjectionField) (string, error) {
+	key := columnsClauseCacheKey(spec, projection)
+
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(e)
+		columns := e.Value.(*columnsClauseCacheEntry).columns
+		c.mu.Unlock()
+		return columns, nil
+	}
+	c.mu.Unlock()
+
+	// The clause is built without holding the lock. Concurrent misses for the
+	// same key may build it more than once, but the result is the same.
+	columns, err := BuildColumnsClauseFromFields(spec, projection)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(e)
+		return columns, nil
+	}
+	c.entries[key] = c.lru.PushFront(&columnsClauseCacheEntry{key: key, columns: columns})
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*columnsClauseCacheEntry).key)
+	}
+	return columns, nil
+}
+
+// Len returns the number of cached columns clauses.
+func (c *ColumnsClauseCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// columnsClauseCacheKey returns a key that identifies the columns clause built from the spec and the projection.
+// The projection is normalized by dropping repeated fields, as they don't change the resulting clause.
+func columnsClauseCacheKey(spec QueryBuilderSpec, projection []ProjectionField) string {
+	sb := strings.Builder{}
+	spec.writeColumnsFingerprint(&sb)
+	sb.WriteByte(0)
+
+	seen := make(map[ProjectionField]struct{}, len(projection))
+	for _, f := range projection {
+		if _, ok := seen[f]; ok {
+			continue
+		}
+		seen[f] = struct{}{}
+		if f.Exclude {
+			sb.WriteByte('-')
+		}
+		sb.WriteString(f.Path)
+		if f.Alias != "" {
+			sb.WriteString(" AS ")
+			sb.WriteString(f.Alias)
+		}
+		sb.WriteByte(',')
+	}
+	return sb.String()
+}
+
+// writeColumnsFingerprint writes everything in the spec that changes how columns clauses are built.
+func (s QueryBuilderSpec) writeColumnsFingerprint(sb *strings.Builder) {
+	repeated := make([]string, 0, len(s.RepeatedColumns))
+	for c := range s.RepeatedColumns {
+		repeated = append(repeated, c)
+	}
+	sort.Strings(repeated)
+	for _, c := range repeated {
+		sb.WriteString(c)
+		sb.WriteByte(',')
+	}
+
+	sb.WriteByte(0)
+	computed := make([]string, 0, len(s.ComputedColumns))
+	for c := range s.ComputedColumns {
+		computed = append(computed, c)
+	}
+	sort.Strings(computed)
+	for _, c := range computed {
+		sb.WriteString(c)
+		sb.WriteByte('=')
+		sb.WriteString(s.ComputedColumns[c])
+		sb.WriteByte(0)
+	}
+
+	// The cache always builds the clauses for the empty role.
+	sb.WriteByte(0)
+	policies := make([]string, 0, len(s.ColumnPolicies))
+	for c := range s.ColumnPolicies {
+		policies = append(policies, c)
+	}
+	sort.Strings(policies)
+	for _, c := range policies {
+		sb.WriteString(c)
+		sb.WriteByte('=')
+		sb.WriteString(strconv.Itoa(int(s.ColumnPolicies[c].Access(""))))
+		sb.WriteByte(',')
+	}
+}
@@ -0,0 +1,132 @@
+package bigqueryutil
+
+import (
+	"io"
+	"strings"
+
+	"github.com/arquivei/foundationkit/errors"
+)
+
+// writeFlat writes all added columns as top level columns named after their full path.
+//
+// wrap converts an expression relative to the elements of the nearest repeated column
+// that was flattened into an expression relative to the table. names holds the paths of
+// the columns already written by their lower cased names, as BigQuery rejects results with
+// two columns of the same name, ignoring case.
+func (b *columnsClauseBuilder) writeFlat(w io.StringWriter, wrap func(string) string, names map[string]string) {
+	opts := b.options
+	for _, c := range b.columns {
+		if opts.err != nil {
+			return
+		}
+		if opts.isJSON(c) {
+			// JSON columns are a single string column, even if they are repeated or have children.
+			sb := strings.Builder{}
+			b.writeSegmentExpr(&sb, c)
+			b.writeFlatColumn(w, wrap(sb.String()), b.flatName(c), c.fullname(), names)
+			continue
+		}
+		switch c._type {
+		case columnNameSegmentStruct:
+			c.childrenBuilder.writeFlat(w, wrap, names)
+			continue
+		case columnNameSegmentArray:
+			if opts.flatRepeated == FlatRepeatedFirst {
+				b.writeFlatFirstElement(w, c, wrap, names)
+				continue
+			}
+		}
+
+		if c.repeated && opts.flatRepeated == FlatRepeatedError {
+			opts.fail(errors.New(c.fullname() + " is a repeated column and can't be flattened"))
+			return
+		}
+
+		sb := strings.Builder{}
+		b.writeSegmentExpr(&sb, c)
+		expr := sb.String()
+		if c.repeated {
+			switch opts.flatRepeated {
+			case FlatRepeatedJSON:
+				expr = "TO_JSON_STRING(" + expr + ")"
+			case FlatRepeatedFirst:
+				if b.isRebuilt(c) {
+					expr = "(" + expr + ")"
+				}
+				expr += "[SAFE_OFFSET(0)]"
+			}
+		}
+		b.writeFlatColumn(w, wrap(expr), b.flatName(c), c.fullname(), names)
+	}
+}
+
+// writeFlatFirstElement writes the columns of the first element of a repeated column.
+func (b *columnsClauseBuilder) writeFlatFirstElement(
+	w io.StringWriter,
+	c *columnNameSegment,
+	wrap func(string) string,
+	names map[string]string,
+) {
+	opts := b.options
+	array := c.fullnameInsideArray()
+
+	if !opts.hasArray(c) {
+		c.childrenBuilder.writeFlat(w, func(expr string) string {
+			if isValidColumnPath(expr) {
+				return wrap(array + "[SAFE_OFFSET(0)]." + expr)
+			}
+			// Computed, masked and rebuilt columns are expressions relative to the elements,
+			// so they are evaluated on the first element by a correlated subquery.
+			return wrap("(SELECT " + expr + " FROM UNNEST(" + array + ") WITH OFFSET o WHERE o = 0)")
+		}, names)
+		return
+	}
+
+	// The options change which element is the first, so each column is read
+	// from a subquery that applies them.
+	sb := strings.Builder{}
+	sb.WriteString(" FROM UNNEST(")
+	sb.WriteString(array)
+	sb.WriteString(")")
+	opts.writeArrayClausesWithLimit(&sb, c, " LIMIT 1")
+	from := sb.String()
+	elementWrap := func(expr string) string {
+		return wrap("(SELECT " + expr + from + ")")
+	}
+
+	if offset := opts.arrays[c.fullname()].offset; offset != "" {
+		b.writeFlatColumn(w, elementWrap(offset), b.flatName(c)+opts.flatSeparator+offset, c.fullname()+" offset", names)
+	}
+	c.childrenBuilder.writeFlat(w, elementWrap, names)
+}
+
+// flatName returns the name of the segment in flat mode.
+func (b *columnsClauseBuilder) flatName(c *columnNameSegment) string {
+	if c.alias != "" {
+		return c.alias
+	}
+	name := c.name
+	for p := c.parent; p != nil; p = p.parent {
+		name = p.outputName() + b.options.flatSeparator + name
+	}
+	return name
+}
+
+// writeFlatColumn writes the expression of the column of the path as the named column. Names
+// already taken by another column fail the columns clause.
+func (b *columnsClauseBuilder) writeFlatColumn(w io.StringWriter, expr, name, path string, names map[string]string) {
+	key := strings.ToLower(name)
+	if other, ok := names[key]; ok {
+		b.options.fail(errors.Errorf("%w: %s and %s are both projected as %s", ErrInvalidProjection, other, path, name))
+		return
+	}
+	if len(names) > 0 {
+		w.WriteString(",")
+	}
+	names[key] = path
+	w.WriteString(expr)
+	if expr != name {
+		w.WriteString(" AS ")
+		w.WriteString(name)
+	}
+}
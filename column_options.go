@@ -0,0 +1,245 @@
+package bigqueryutil
+
+import (
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/arquivei/foundationkit/errors"
+)
+
+// columnsClauseOptions holds the compiled ColumnsClauseOptions, shared by all the builders of a columns clause.
+type columnsClauseOptions struct {
+	arrays map[string]*arrayClauses
+	json   map[string]struct{}
+	// params holds the parameters of the clauses that were written
+	params []bigquery.QueryParameter
+
+	flat          bool
+	flatSeparator string
+	flatRepeated  FlatRepeatedMode
+
+	role string
+	// denied and masked hold the columns with policies that deny or mask them for the role
+	denied []string
+	masked []string
+
+	// unchecked, if true, writes invalid projection fields and output name collisions as
+	// given, for BuildColumnsClause, that can't report them
+	unchecked bool
+
+	// err holds the first error found while writing the columns clause
+	err error
+}
+
+// arrayClauses holds the clauses appended to the subquery that rebuilds a repeated column.
+type arrayClauses struct {
+	// offset is the name of the column holding the element offset, if it should be projected
+	offset  string
+	clauses string
+	limit   string
+	params  []bigquery.QueryParameter
+	written bool
+}
+
+func compileColumnsClauseOptions(spec QueryBuilderSpec, opts ColumnsClauseOptions) (*columnsClauseOptions, error) {
+	const op = errors.Op("bigqueryutil.compileColumnsClauseOptions")
+
+	options := &columnsClauseOptions{
+		flat:          opts.Flat,
+		flatSeparator: opts.FlatSeparator,
+		flatRepeated:  opts.FlatRepeated,
+		role:          opts.Role,
+	}
+	options.denied, options.masked = spec.restrictedColumns(opts.Role)
+	if options.flatSeparator == "" {
+		options.flatSeparator = DefaultFlatSeparator
+	}
+	if options.flat && !isValidColumnName("a"+options.flatSeparator+"b") {
+		return nil, errors.E(op, errors.New("invalid flat separator: "+options.flatSeparator))
+	}
+	if len(opts.Arrays) > 0 {
+		options.arrays = make(map[string]*arrayClauses, len(opts.Arrays))
+	}
+	for path := range opts.JSON {
+		if !isValidColumnPath(path) {
+			return nil, errors.E(op, errors.New("invalid JSON column: "+path))
+		}
+	}
+	options.json = opts.JSON
+
+	for path, arrayOpts := range opts.Arrays {
+		if !isRepeated(spec, path) {
+			return nil, errors.E(op, errors.New(path+" is not a repeated column"))
+		}
+		clauses, err := compileArrayOptions(path, arrayOpts)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+		options.arrays[path] = clauses
+	}
+	return options, nil
+}
+
+// compileArrayOptions builds the WHERE, ORDER BY and LIMIT clauses of a repeated column.
+func compileArrayOptions(path string, opts ArrayOptions) (*arrayClauses, error) {
+	sb := strings.Builder{}
+	clauses := &arrayClauses{}
+
+	if opts.WithOffset {
+		clauses.offset = opts.OffsetName
+		if clauses.offset == "" {
+			clauses.offset = DefaultOffsetName
+		}
+		if !isValidColumnName(clauses.offset) {
+			return nil, errors.New(path + " has an invalid offset name: " + clauses.offset)
+		}
+		sb.WriteString(" WITH OFFSET AS ")
+		sb.WriteString(clauses.offset)
+	}
+
+	if opts.Filter != nil {
+		paramPrefix := strings.ReplaceAll(path, ".", "_") + "_"
+		where, params, err := encodeWhereClause(opts.Filter, paramPrefix)
+		if err != nil {
+			return nil, err
+		}
+		if where != "" {
+			sb.WriteString(" WHERE ")
+			sb.WriteString(where)
+			clauses.params = params
+		}
+	}
+
+	orderBy := opts.OrderBy
+	if clauses.offset != "" {
+		// The offset is always the last sort key, so the order of the elements is deterministic.
+		orderBy = append(orderBy[:len(orderBy):len(orderBy)], OrderBy{Column: clauses.offset})
+	}
+	if err := writeOrderBy(&sb, orderBy); err != nil {
+		return nil, err
+	}
+
+	if opts.Limit < 0 {
+		return nil, errors.New(path + " has a negative limit")
+	}
+	if opts.Limit > 0 {
+		clauses.limit = " LIMIT " + strconv.Itoa(opts.Limit)
+	}
+
+	clauses.clauses = sb.String()
+	return clauses, nil
+}
+
+// writeOrderBy writes the ORDER BY clause, prefixed by a space, if there is any column to order by.
+func writeOrderBy(sb *strings.Builder, orderBy []OrderBy) error {
+	for i, o := range orderBy {
+		if !isValidColumnPath(o.Column) {
+			return errors.New("invalid order by column: " + o.Column)
+		}
+		if i == 0 {
+			sb.WriteString(" ORDER BY ")
+		} else {
+			sb.WriteString(",")
+		}
+		sb.WriteString(o.String())
+	}
+	return nil
+}
+
+// deniedPaths returns the columns denied to the role.
+func (o *columnsClauseOptions) deniedPaths() []string {
+	if o == nil {
+		return nil
+	}
+	return o.denied
+}
+
+// isUnchecked checks if the projection fields are written without being validated.
+func (o *columnsClauseOptions) isUnchecked() bool {
+	return o != nil && o.unchecked
+}
+
+// roleName returns the role the options are built for.
+func (o *columnsClauseOptions) roleName() string {
+	if o == nil {
+		return ""
+	}
+	return o.role
+}
+
+// fail records the error, unless an error was already recorded.
+func (o *columnsClauseOptions) fail(err error) {
+	if o != nil && o.err == nil {
+		o.err = err
+	}
+}
+
+// hasArray checks if there are options for the repeated column of the segment.
+func (o *columnsClauseOptions) hasArray(c *columnNameSegment) bool {
+	if o == nil || !c.repeated || len(o.arrays) == 0 {
+		return false
+	}
+	_, ok := o.arrays[c.fullname()]
+	return ok
+}
+
+// isJSON checks if the column of the segment should be projected as a JSON string.
+func (o *columnsClauseOptions) isJSON(c *columnNameSegment) bool {
+	if o == nil || len(o.json) == 0 {
+		return false
+	}
+	_, ok := o.json[c.fullname()]
+	return ok
+}
+
+// rebuiltPaths returns the paths of the columns that must be rebuilt to apply their options,
+// even if they are inside a column projected as a whole.
+func (o *columnsClauseOptions) rebuiltPaths() []string {
+	if o == nil || len(o.arrays)+len(o.json)+len(o.masked) == 0 {
+		return nil
+	}
+	paths := make([]string, 0, len(o.arrays)+len(o.json)+len(o.masked))
+	for path := range o.arrays {
+		paths = append(paths, path)
+	}
+	for path := range o.json {
+		paths = append(paths, path)
+	}
+	paths = append(paths, o.masked...)
+	sort.Strings(paths)
+	return paths
+}
+
+// writeArrayOffset writes the offset column of the repeated column of the segment, followed by a comma, if any.
+// It is only needed when the columns of the array are listed, as "SELECT *" already includes the offset.
+func (o *columnsClauseOptions) writeArrayOffset(w io.StringWriter, c *columnNameSegment) {
+	if !o.hasArray(c) {
+		return
+	}
+	if offset := o.arrays[c.fullname()].offset; offset != "" {
+		w.WriteString(offset)
+		w.WriteString(",")
+	}
+}
+
+// writeArrayClauses writes the clauses of the repeated column of the segment, if any.
+func (o *columnsClauseOptions) writeArrayClauses(w io.StringWriter, c *columnNameSegment) {
+	if !o.hasArray(c) {
+		return
+	}
+	o.writeArrayClausesWithLimit(w, c, o.arrays[c.fullname()].limit)
+}
+
+// writeArrayClausesWithLimit is like writeArrayClauses, but replaces the limit of the repeated column.
+func (o *columnsClauseOptions) writeArrayClausesWithLimit(w io.StringWriter, c *columnNameSegment, limit string) {
+	clauses := o.arrays[c.fullname()]
+	w.WriteString(clauses.clauses)
+	w.WriteString(limit)
+	if !clauses.written {
+		clauses.written = true
+		o.params = append(o.params, clauses.params...)
+	}
+}
@@ -0,0 +1,98 @@
+package bigqueryutil
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/arquivei/foundationkit/errors"
+)
+
+// ErrColumnDenied is returned when a projected column is denied to the caller's role.
+var ErrColumnDenied = errors.New("column access denied")
+
+// BuildColumnsClauseForRole is like BuildColumnsClause, but applies the spec's column policies
+// for the given role.
+//
+// Projecting a denied column returns ErrColumnDenied, and masked columns are projected
+// with the masking expression under their original names. Computed columns are denied
+// unless they have a policy of their own, as their expressions may read denied columns. Columns projected as a whole,
+// or the whole table if the projection is empty, have their denied children excluded and
+// their masked children replaced.
+func BuildColumnsClauseForRole(spec QueryBuilderSpec, role string, projection []string) (string, error) {
+	fields := make([]ProjectionField, len(projection))
+	for i, p := range projection {
+		fields[i] = ParseProjectionField(p)
+	}
+	columns, _, err := BuildColumnsClauseWithOptions(spec, fields, ColumnsClauseOptions{Role: role})
+	return columns, err
+}
+
+// columnAccess returns the access of the role to the column, given by the policy of
+// the column or of its nearest parent with a policy.
+func (s QueryBuilderSpec) columnAccess(path string, role string) ColumnAccess {
+	if len(s.ColumnPolicies) == 0 {
+		return ColumnAllow
+	}
+	for {
+		if p, ok := s.ColumnPolicies[path]; ok {
+			return p.Access(role)
+		}
+		i := strings.LastIndexByte(path, '.')
+		if i < 0 {
+			return ColumnAllow
+		}
+		path = path[:i]
+	}
+}
+
+// computedColumnAccess returns the access of the role to the computed column. As the expression
+// may read any column, computed columns don't inherit the policies of their parents: they must
+// have a policy of their own, or they are denied.
+func (s QueryBuilderSpec) computedColumnAccess(path string, role string) ColumnAccess {
+	if len(s.ColumnPolicies) == 0 {
+		return ColumnAllow
+	}
+	if p, ok := s.ColumnPolicies[path]; ok {
+		return p.Access(role)
+	}
+	return ColumnDeny
+}
+
+// checkComputedColumnPolicies checks that every computed column has a policy of its own
+// if the spec has column policies, see computedColumnAccess.
+func (s QueryBuilderSpec) checkComputedColumnPolicies() error {
+	if len(s.ColumnPolicies) == 0 {
+		return nil
+	}
+	computed := make([]string, 0, len(s.ComputedColumns))
+	for c := range s.ComputedColumns {
+		computed = append(computed, c)
+	}
+	sort.Strings(computed)
+	for _, c := range computed {
+		if _, ok := s.ColumnPolicies[c]; !ok {
+			return errors.Errorf("%w: computed column %s has no column policy", ErrInvalidSpec, c)
+		}
+	}
+	return nil
+}
+
+// restrictedColumns returns the columns whose own policy denies or masks them for the role.
+// Computed columns are not part of the table, so there is nothing to exclude or replace:
+// their policies are applied where they are projected.
+func (s QueryBuilderSpec) restrictedColumns(role string) (denied []string, masked []string) {
+	for path, p := range s.ColumnPolicies {
+		if _, ok := s.ComputedColumns[path]; ok {
+			continue
+		}
+		switch p.Access(role) {
+		case ColumnDeny:
+			denied = append(denied, path)
+		case ColumnMaskSHA256, ColumnMaskNull:
+			masked = append(masked, path)
+		}
+	}
+	sort.Strings(denied)
+	sort.Strings(masked)
+	return denied, masked
+}
@@ -0,0 +1,224 @@
+package bigqueryutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildColumnsClauseForRole(t *testing.T) {
+	t.Parallel()
+	spec := QueryBuilderSpec{
+		RepeatedColumns: map[string]struct{}{
+			"NFe.infNFe.det": {},
+		},
+		ColumnPolicies: map[string]ColumnPolicy{
+			"NFe.infNFe.dest": {
+				Default: ColumnDeny,
+				Roles: map[string]ColumnAccess{
+					"admin":   ColumnAllow,
+					"auditor": ColumnMaskNull,
+				},
+			},
+			"NFe.infNFe.dest.enderDest": {
+				Default: ColumnAllow,
+			},
+			"NFe.infNFe.emit.CPF": {
+				Default: ColumnMaskSHA256,
+				Roles: map[string]ColumnAccess{
+					"admin": ColumnAllow,
+				},
+			},
+			"NFe.infNFe.det.prod.vProd": {
+				Default: ColumnMaskNull,
+			},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		role       string
+		projection []string
+		expected   string
+		wantErr    bool
+	}{
+		{
+			name:       "allowed columns",
+			role:       "admin",
+			projection: []string{"AccessKey", "NFe.infNFe.dest.CPF", "NFe.infNFe.emit.CPF"},
+			expected:   "AccessKey,STRUCT(STRUCT(STRUCT(NFe.infNFe.dest.CPF) AS dest,STRUCT(NFe.infNFe.emit.CPF) AS emit) AS infNFe) AS NFe",
+		},
+		{
+			name:       "denied column",
+			projection: []string{"AccessKey", "NFe.infNFe.dest.CPF"},
+			wantErr:    true,
+		},
+		{
+			name:       "denied column projected as a whole",
+			projection: []string{"NFe.infNFe.dest"},
+			wantErr:    true,
+		},
+		{
+			name:       "allowed child of denied column",
+			projection: []string{"NFe.infNFe.dest.enderDest.UF"},
+			expected:   "STRUCT(STRUCT(STRUCT(STRUCT(NFe.infNFe.dest.enderDest.UF) AS enderDest) AS dest) AS infNFe) AS NFe",
+		},
+		{
+			name:       "masked columns",
+			role:       "auditor",
+			projection: []string{"NFe.infNFe.dest.CPF AS DestCPF", "NFe.infNFe.emit.CPF", "NFe.infNFe.det.prod.vProd"},
+			expected: "STRUCT(STRUCT(STRUCT(NULL AS DestCPF) AS dest," +
+				"STRUCT(TO_HEX(SHA256(TO_JSON_STRING(NFe.infNFe.emit.CPF))) AS CPF) AS emit," +
+				"ARRAY(SELECT AS STRUCT STRUCT(NULL AS vProd) AS prod FROM UNNEST(NFe.infNFe.det)) AS det) AS infNFe) AS NFe",
+		},
+		{
+			name:       "columns projected as a whole",
+			projection: []string{"AccessKey", "NFe.infNFe"},
+			expected: "AccessKey,STRUCT((SELECT AS STRUCT NFe.infNFe.* EXCEPT(dest) REPLACE(" +
+				"ARRAY(SELECT AS STRUCT * REPLACE((SELECT AS STRUCT prod.* REPLACE(NULL AS vProd)) AS prod) FROM UNNEST(NFe.infNFe.det)) AS det," +
+				"(SELECT AS STRUCT NFe.infNFe.emit.* REPLACE(TO_HEX(SHA256(TO_JSON_STRING(NFe.infNFe.emit.CPF))) AS CPF)) AS emit)) AS infNFe) AS NFe",
+		},
+		{
+			name: "whole table",
+			role: "auditor",
+			expected: "* REPLACE((SELECT AS STRUCT NFe.* REPLACE((SELECT AS STRUCT NFe.infNFe.* REPLACE(" +
+				"NULL AS dest," +
+				"ARRAY(SELECT AS STRUCT * REPLACE((SELECT AS STRUCT prod.* REPLACE(NULL AS vProd)) AS prod) FROM UNNEST(NFe.infNFe.det)) AS det," +
+				"(SELECT AS STRUCT NFe.infNFe.emit.* REPLACE(TO_HEX(SHA256(TO_JSON_STRING(NFe.infNFe.emit.CPF))) AS CPF)) AS emit)) AS infNFe)) AS NFe)",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			columns, err := BuildColumnsClauseForRole(spec, test.role, test.projection)
+			if test.wantErr {
+				assert.ErrorIs(t, err, ErrColumnDenied)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, test.expected, columns)
+		})
+	}
+}
+
+func TestBuildColumnsClauseWithColumnPolicies(t *testing.T) {
+	t.Parallel()
+	spec := QueryBuilderSpec{
+		ColumnPolicies: map[string]ColumnPolicy{
+			"RawXML": {Default: ColumnDeny},
+		},
+	}
+	assert.Equal(t, "* EXCEPT(RawXML)", BuildColumnsClause(spec, nil))
+	assert.Equal(t, "AccessKey", BuildColumnsClause(spec, []string{"AccessKey"}))
+	assert.Equal(t, "", BuildColumnsClause(spec, []string{"AccessKey", "RawXML"}))
+}
+
+func TestBuildColumnsClauseWithMaskedRecords(t *testing.T) {
+	t.Parallel()
+	spec := QueryBuilderSpec{
+		RepeatedColumns: map[string]struct{}{
+			"NFe.infNFe.det": {},
+		},
+		ColumnPolicies: map[string]ColumnPolicy{
+			"NFe.infNFe.emit":      {Default: ColumnMaskSHA256},
+			"NFe.infNFe.emit.UF":   {Default: ColumnAllow},
+			"NFe.infNFe.det":       {Default: ColumnMaskSHA256},
+			"NFe.infNFe.dest.CNPJ": {Default: ColumnMaskSHA256},
+			"NFe.infNFe.transp":    {Default: ColumnMaskSHA256},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		projection []string
+		expected   string
+	}{
+		{
+			name:       "struct",
+			projection: []string{"NFe.infNFe.emit"},
+			expected:   "STRUCT(STRUCT(TO_HEX(SHA256(TO_JSON_STRING(NFe.infNFe.emit))) AS emit) AS infNFe) AS NFe",
+		},
+		{
+			name:       "array",
+			projection: []string{"NFe.infNFe.det"},
+			expected:   "STRUCT(STRUCT(TO_HEX(SHA256(TO_JSON_STRING(NFe.infNFe.det))) AS det) AS infNFe) AS NFe",
+		},
+		{
+			name:       "struct the spec doesn't otherwise mention",
+			projection: []string{"NFe.infNFe.transp"},
+			expected:   "STRUCT(STRUCT(TO_HEX(SHA256(TO_JSON_STRING(NFe.infNFe.transp))) AS transp) AS infNFe) AS NFe",
+		},
+		{
+			name:       "scalar",
+			projection: []string{"NFe.infNFe.dest.CNPJ"},
+			expected:   "STRUCT(STRUCT(STRUCT(TO_HEX(SHA256(TO_JSON_STRING(NFe.infNFe.dest.CNPJ))) AS CNPJ) AS dest) AS infNFe) AS NFe",
+		},
+		{
+			name: "whole table",
+			expected: "* REPLACE((SELECT AS STRUCT NFe.* REPLACE((SELECT AS STRUCT NFe.infNFe.* REPLACE(" +
+				"(SELECT AS STRUCT NFe.infNFe.dest.* REPLACE(TO_HEX(SHA256(TO_JSON_STRING(NFe.infNFe.dest.CNPJ))) AS CNPJ)) AS dest," +
+				"TO_HEX(SHA256(TO_JSON_STRING(NFe.infNFe.det))) AS det," +
+				"TO_HEX(SHA256(TO_JSON_STRING(NFe.infNFe.emit))) AS emit," +
+				"TO_HEX(SHA256(TO_JSON_STRING(NFe.infNFe.transp))) AS transp)) AS infNFe)) AS NFe)",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			columns, err := BuildColumnsClauseForRole(spec, "", test.projection)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, columns)
+		})
+	}
+}
+
+func TestBuildColumnsClauseWithComputedColumnPolicies(t *testing.T) {
+	t.Parallel()
+	spec := QueryBuilderSpec{
+		ComputedColumns: map[string]string{
+			"X":                   "CPF",
+			"NFe.infNFe.dest.Doc": "COALESCE(NFe.infNFe.dest.CNPJ, NFe.infNFe.dest.CPF)",
+			"EventsCount":         "ARRAY_LENGTH(Events)",
+			"NFe.infNFe.emit.Doc": "COALESCE(NFe.infNFe.emit.CNPJ, NFe.infNFe.emit.CPF)",
+		},
+		ColumnPolicies: map[string]ColumnPolicy{
+			"CPF":                 {Default: ColumnDeny},
+			"NFe.infNFe.emit.Doc": {Default: ColumnDeny},
+			"NFe.infNFe.dest.Doc": {Default: ColumnMaskSHA256, Roles: map[string]ColumnAccess{"admin": ColumnAllow}},
+			"EventsCount":         {Default: ColumnAllow},
+		},
+	}
+	assert.ErrorIs(t, spec.Validate(), ErrInvalidSpec)
+
+	// Computed columns without a policy of their own are denied, even if the columns they read are not.
+	_, err := BuildColumnsClauseForRole(spec, "", []string{"AccessKey", "X"})
+	assert.ErrorIs(t, err, ErrColumnDenied)
+
+	columns, err := BuildColumnsClauseForRole(spec, "", []string{"EventsCount", "NFe.infNFe.dest.Doc"})
+	assert.NoError(t, err)
+	assert.Equal(t, "ARRAY_LENGTH(Events) AS EventsCount,"+
+		"STRUCT(STRUCT(STRUCT(TO_HEX(SHA256(TO_JSON_STRING(COALESCE(NFe.infNFe.dest.CNPJ, NFe.infNFe.dest.CPF)))) AS Doc) AS dest) AS infNFe) AS NFe",
+		columns)
+
+	columns, err = BuildColumnsClauseForRole(spec, "admin", []string{"NFe.infNFe.dest.Doc"})
+	assert.NoError(t, err)
+	assert.Equal(t, "STRUCT(STRUCT(STRUCT(COALESCE(NFe.infNFe.dest.CNPJ, NFe.infNFe.dest.CPF) AS Doc) AS dest) AS infNFe) AS NFe", columns)
+
+	// Denied and masked computed columns are not in the table, so the parents projected as
+	// a whole don't exclude or replace them.
+	columns, err = BuildColumnsClauseForRole(spec, "", []string{"NFe"})
+	assert.NoError(t, err)
+	assert.Equal(t, "NFe", columns)
+	columns, err = BuildColumnsClauseForRole(spec, "", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "* EXCEPT(CPF)", columns)
+	_, err = BuildColumnsClauseForRole(spec, "", []string{"NFe", "NFe.infNFe.emit.Doc"})
+	assert.ErrorIs(t, err, ErrColumnDenied)
+
+	spec.ColumnPolicies["X"] = ColumnPolicy{Default: ColumnDeny}
+	assert.NoError(t, spec.Validate())
+}
@@ -1,9 +1,11 @@
 package bigqueryutil
 
 import (
+	"strconv"
 	"strings"
 	"testing"
 
+	"cloud.google.com/go/bigquery"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -115,6 +117,152 @@ func TestBuildColumnsClause(t *testing.T) {
 					"FROM %s WHERE %s%s) WHERE r = 1;",
 			},
 		},
+		{
+			name:       "aliased fields",
+			projection: []string{"AccessKey AS Key", "NFe.infNFe.emit.CNPJ as EmitterCNPJ", "Events.Date AS EventDate"},
+			expected:   "AccessKey AS Key,STRUCT(STRUCT(STRUCT(NFe.infNFe.emit.CNPJ AS EmitterCNPJ) AS emit) AS infNFe) AS NFe,ARRAY(SELECT AS STRUCT Date AS EventDate FROM UNNEST(Events)) AS Events",
+			spec: QueryBuilderSpec{
+				RepeatedColumns: map[string]struct{}{
+					"Events": {},
+				},
+			},
+		},
+		{
+			name:       "aliased field inside array inside struct",
+			projection: []string{"NFe.infNFe.det.imposto.ICMS.ICMS00.vICMS AS ICMSValue"},
+			expected:   "STRUCT(STRUCT(ARRAY(SELECT AS STRUCT STRUCT(STRUCT(STRUCT(imposto.ICMS.ICMS00.vICMS AS ICMSValue) AS ICMS00) AS ICMS) AS imposto FROM UNNEST(NFe.infNFe.det)) AS det) AS infNFe) AS NFe",
+			spec: QueryBuilderSpec{
+				RepeatedColumns: map[string]struct{}{
+					"NFe.infNFe.det": {},
+				},
+			},
+		},
+		{
+			name:       "top level exclusions",
+			projection: []string{"-RawXML", "-Events"},
+			expected:   "* EXCEPT(RawXML,Events)",
+			spec: QueryBuilderSpec{
+				RepeatedColumns: map[string]struct{}{
+					"Events": {},
+				},
+			},
+		},
+		{
+			name:       "nested exclusions",
+			projection: []string{"-RawXML", "-NFe.Signature", "-NFe.infNFe.det.prod.DI", "-NFe.infNFe.emit.enderEmit"},
+			expected: "* EXCEPT(RawXML) REPLACE((SELECT AS STRUCT NFe.* EXCEPT(Signature) REPLACE(" +
+				"(SELECT AS STRUCT NFe.infNFe.* REPLACE(" +
+				"ARRAY(SELECT AS STRUCT * REPLACE((SELECT AS STRUCT prod.* EXCEPT(DI)) AS prod) FROM UNNEST(NFe.infNFe.det)) AS det," +
+				"(SELECT AS STRUCT NFe.infNFe.emit.* EXCEPT(enderEmit)) AS emit)) AS infNFe)) AS NFe)",
+			spec: QueryBuilderSpec{
+				RepeatedColumns: map[string]struct{}{
+					"NFe.infNFe.det": {},
+				},
+			},
+		},
+		{
+			name:       "exclusion subsumed by parent exclusion",
+			projection: []string{"-NFe.Signature.SignatureValue", "-NFe.Signature"},
+			expected:   "* REPLACE((SELECT AS STRUCT NFe.* EXCEPT(Signature)) AS NFe)",
+		},
+		{
+			name:       "exclusions from columns projected as a whole",
+			projection: []string{"-NFe.Signature", "AccessKey", "NFe AS Document", "Events", "-Events.Payload", "-Unknown", "-Events.Payload"},
+			expected: "AccessKey,(SELECT AS STRUCT NFe.* EXCEPT(Signature)) AS Document," +
+				"ARRAY(SELECT AS STRUCT * EXCEPT(Payload) FROM UNNEST(Events)) AS Events",
+			spec: QueryBuilderSpec{
+				RepeatedColumns: map[string]struct{}{
+					"Events": {},
+				},
+			},
+		},
+		{
+			name:       "exclusions inside partially projected structs",
+			projection: []string{"NFe.infNFe.emit", "NFe.infNFe.det", "-NFe.infNFe.emit.enderEmit", "-NFe.infNFe.det.prod"},
+			expected: "STRUCT(STRUCT((SELECT AS STRUCT NFe.infNFe.emit.* EXCEPT(enderEmit)) AS emit," +
+				"ARRAY(SELECT AS STRUCT * EXCEPT(prod) FROM UNNEST(NFe.infNFe.det)) AS det) AS infNFe) AS NFe",
+			spec: QueryBuilderSpec{
+				RepeatedColumns: map[string]struct{}{
+					"NFe.infNFe.det": {},
+				},
+			},
+		},
+		{
+			name:       "duplicated fields",
+			projection: []string{"AccessKey", "Events.Date", "AccessKey", "Events.Date", "AccessKey AS Key"},
+			expected:   "AccessKey,ARRAY(SELECT AS STRUCT Date FROM UNNEST(Events)) AS Events,AccessKey AS Key",
+			spec: QueryBuilderSpec{
+				RepeatedColumns: map[string]struct{}{
+					"Events": {},
+				},
+			},
+		},
+		{
+			name:       "parent field after its children",
+			projection: []string{"NFe.infNFe.emit.CNPJ", "AccessKey", "NFe.infNFe.ide.nNF", "NFe.infNFe", "NFe.infNFe.emit.xNome"},
+			expected:   "STRUCT(NFe.infNFe) AS NFe,AccessKey",
+		},
+		{
+			name:       "parent field before its children",
+			projection: []string{"Events", "Events.Date", "NFe.infNFe", "NFe.infNFe.emit.CNPJ AS EmitterCNPJ"},
+			expected:   "Events,STRUCT(NFe.infNFe) AS NFe",
+			spec: QueryBuilderSpec{
+				RepeatedColumns: map[string]struct{}{
+					"Events": {},
+				},
+			},
+		},
+		{
+			name:       "aliased parent field does not subsume its children",
+			projection: []string{"NFe.infNFe AS Document", "NFe.infNFe.emit.CNPJ"},
+			expected:   "STRUCT(NFe.infNFe AS Document,STRUCT(STRUCT(NFe.infNFe.emit.CNPJ) AS emit) AS infNFe) AS NFe",
+		},
+		{
+			name:       "computed columns",
+			projection: []string{"AccessKey", "CreatedDate", "EventsCount AS TotalEvents", "NFe.infNFe.emit.Doc", "NFe.infNFe.det.Total", "NFe.infNFe.det._nItem"},
+			expected: "AccessKey,DATE(CreatedAt) AS CreatedDate,ARRAY_LENGTH(Events) AS TotalEvents," +
+				"STRUCT(STRUCT(STRUCT(COALESCE(NFe.infNFe.emit.CNPJ, NFe.infNFe.emit.CPF) AS Doc) AS emit," +
+				"ARRAY(SELECT AS STRUCT prod.vProd - prod.vDesc AS Total,_nItem FROM UNNEST(NFe.infNFe.det)) AS det) AS infNFe) AS NFe",
+			spec: QueryBuilderSpec{
+				RepeatedColumns: map[string]struct{}{
+					"Events":         {},
+					"NFe.infNFe.det": {},
+				},
+				ComputedColumns: map[string]string{
+					"CreatedDate":          "DATE(CreatedAt)",
+					"EventsCount":          "ARRAY_LENGTH(Events)",
+					"NFe.infNFe.emit.Doc":  "COALESCE(NFe.infNFe.emit.CNPJ, NFe.infNFe.emit.CPF)",
+					"NFe.infNFe.det.Total": "prod.vProd - prod.vDesc",
+				},
+			},
+		},
+		{
+			name:       "computed columns inside columns projected as a whole",
+			projection: []string{"NFe", "NFe.infNFe.det.Total", "NFe.infNFe.emit.Doc AS EmitterDoc"},
+			expected: "(SELECT AS STRUCT NFe.* REPLACE((SELECT AS STRUCT NFe.infNFe.* REPLACE(" +
+				"ARRAY(SELECT AS STRUCT *,prod.vProd - prod.vDesc AS Total FROM UNNEST(NFe.infNFe.det)) AS det," +
+				"(SELECT AS STRUCT NFe.infNFe.emit.*,COALESCE(NFe.infNFe.emit.CNPJ, NFe.infNFe.emit.CPF) AS EmitterDoc) AS emit)) AS infNFe)) AS NFe",
+			spec: QueryBuilderSpec{
+				RepeatedColumns: map[string]struct{}{
+					"NFe.infNFe.det": {},
+				},
+				ComputedColumns: map[string]string{
+					"NFe.infNFe.emit.Doc":  "COALESCE(NFe.infNFe.emit.CNPJ, NFe.infNFe.emit.CPF)",
+					"NFe.infNFe.det.Total": "prod.vProd - prod.vDesc",
+				},
+			},
+		},
+		{
+			name:       "computed column projected before its parent",
+			projection: []string{"NFe.infNFe.emit.Doc", "NFe.infNFe.emit", "-NFe.infNFe.emit.CPF"},
+			expected: "STRUCT(STRUCT((SELECT AS STRUCT NFe.infNFe.emit.* EXCEPT(CPF)," +
+				"COALESCE(NFe.infNFe.emit.CNPJ, NFe.infNFe.emit.CPF) AS Doc) AS emit) AS infNFe) AS NFe",
+			spec: QueryBuilderSpec{
+				ComputedColumns: map[string]string{
+					"NFe.infNFe.emit.Doc": "COALESCE(NFe.infNFe.emit.CNPJ, NFe.infNFe.emit.CPF)",
+				},
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -343,3 +491,611 @@ func TestStructFieldBuilder(t *testing.T) {
 		})
 	}
 }
+
+func TestParseProjectionField(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in       string
+		expected ProjectionField
+	}{
+		{in: "AccessKey", expected: ProjectionField{Path: "AccessKey"}},
+		{in: " NFe.infNFe ", expected: ProjectionField{Path: "NFe.infNFe"}},
+		{in: "NFe.infNFe.emit.CNPJ AS EmitterCNPJ", expected: ProjectionField{Path: "NFe.infNFe.emit.CNPJ", Alias: "EmitterCNPJ"}},
+		{in: "Events.Date  as  EventDate", expected: ProjectionField{Path: "Events.Date", Alias: "EventDate"}},
+		{in: "-NFe.Signature", expected: ProjectionField{Path: "NFe.Signature", Exclude: true}},
+		{in: " - RawXML", expected: ProjectionField{Path: "RawXML", Exclude: true}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.in, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, test.expected, ParseProjectionField(test.in))
+		})
+	}
+}
+
+func TestBuildColumnsClauseFromFields(t *testing.T) {
+	t.Parallel()
+	spec := QueryBuilderSpec{
+		RepeatedColumns: map[string]struct{}{
+			"Events": {},
+		},
+	}
+	columns, err := BuildColumnsClauseFromFields(spec, []ProjectionField{
+		{Path: "AccessKey"},
+		{Path: "Events.Date", Alias: "EventDate"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "AccessKey,ARRAY(SELECT AS STRUCT Date AS EventDate FROM UNNEST(Events)) AS Events", columns)
+}
+
+func TestBuildColumnsClauseInvalidProjection(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name       string
+		projection []string
+		wantErr    string
+	}{
+		{
+			name:       "missing alias",
+			projection: []string{"A AS"},
+			wantErr:    `invalid projection: invalid column "A AS"`,
+		},
+		{
+			name:       "invalid alias",
+			projection: []string{"A AS B;DROP"},
+			wantErr:    `invalid projection: invalid alias "B;DROP" of A`,
+		},
+		{
+			name:       "invalid column",
+			projection: []string{"NFe..infNFe"},
+			wantErr:    `invalid projection: invalid column "NFe..infNFe"`,
+		},
+		{
+			name:       "alias collides with a column",
+			projection: []string{"A AS B", "B"},
+			wantErr:    "invalid projection: A and B are both projected as B",
+		},
+		{
+			name:       "alias collides with a struct",
+			projection: []string{"A.x", "C AS A"},
+			wantErr:    "invalid projection: A and C are both projected as A",
+		},
+		{
+			name:       "struct collides with an alias",
+			projection: []string{"C AS A", "A.x"},
+			wantErr:    "invalid projection: C and A are both projected as A",
+		},
+		{
+			name:       "nested aliases collide",
+			projection: []string{"NFe.infNFe.emit.CNPJ AS Document", "NFe.infNFe.emit.CPF AS Document"},
+			wantErr:    "invalid projection: NFe.infNFe.emit.CNPJ and NFe.infNFe.emit.CPF are both projected as Document",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			fields := make([]ProjectionField, len(test.projection))
+			for i, p := range test.projection {
+				fields[i] = ParseProjectionField(p)
+			}
+			columns, _, err := BuildColumnsClauseWithOptions(QueryBuilderSpec{}, fields, ColumnsClauseOptions{})
+			assert.ErrorIs(t, err, ErrInvalidProjection)
+			assert.EqualError(t, err, test.wantErr)
+			assert.Empty(t, columns)
+		})
+	}
+
+	_, _, err := BuildColumnsClauseWithOptions(QueryBuilderSpec{}, []ProjectionField{
+		{Path: "A", Alias: "1B"},
+	}, ColumnsClauseOptions{})
+	assert.EqualError(t, err, `invalid projection: invalid alias "1B" of A`)
+}
+
+func TestBuildColumnsClauseDoesNotValidateProjection(t *testing.T) {
+	t.Parallel()
+	// BuildColumnsClause can't report errors, so it writes the projection as given.
+	assert.Equal(t, "`weird col`,Événement,STRUCT(STRUCT(NFe.`infNFe x`.emit) AS `infNFe x`) AS NFe",
+		BuildColumnsClause(QueryBuilderSpec{}, []string{"`weird col`", "Événement", "NFe.`infNFe x`.emit"}))
+	assert.Equal(t, "A AS B,B", BuildColumnsClause(QueryBuilderSpec{}, []string{"A AS B", "B"}))
+
+	_, err := BuildColumnsClauseFromFields(QueryBuilderSpec{}, []ProjectionField{{Path: "Événement"}})
+	assert.ErrorIs(t, err, ErrInvalidProjection)
+}
+
+func TestBuildColumnsClauseComputedInsideExcludedColumn(t *testing.T) {
+	t.Parallel()
+	spec := QueryBuilderSpec{
+		ComputedColumns: map[string]string{
+			"NFe.infNFe.emit.Doc": "COALESCE(NFe.infNFe.emit.CNPJ, NFe.infNFe.emit.CPF)",
+		},
+	}
+	columns, err := BuildColumnsClauseFromFields(spec, []ProjectionField{
+		{Path: "NFe"},
+		{Path: "NFe.infNFe", Exclude: true},
+		{Path: "NFe.infNFe.emit.Doc"},
+	})
+	assert.EqualError(t, err, "invalid projection: NFe.infNFe.emit.Doc is computed inside the excluded column NFe.infNFe")
+	assert.Empty(t, columns)
+}
+
+func TestColumnsClauseCache(t *testing.T) {
+	t.Parallel()
+	spec := QueryBuilderSpec{
+		RepeatedColumns: map[string]struct{}{
+			"Events": {},
+		},
+	}
+	cache := NewColumnsClauseCache(2)
+	build := func(spec QueryBuilderSpec, projection ...string) string {
+		columns, err := cache.BuildColumnsClause(spec, projection)
+		assert.NoError(t, err)
+		return columns
+	}
+
+	assert.Equal(t, "AccessKey,ARRAY(SELECT AS STRUCT Date FROM UNNEST(Events)) AS Events",
+		build(spec, "AccessKey", "Events.Date"))
+	assert.Equal(t, "AccessKey,ARRAY(SELECT AS STRUCT Date FROM UNNEST(Events)) AS Events",
+		build(spec, "AccessKey", "Events.Date", "AccessKey"))
+	assert.Equal(t, 1, cache.Len())
+
+	// Same projection, but a different spec.
+	assert.Equal(t, "AccessKey,STRUCT(Events.Date) AS Events",
+		build(QueryBuilderSpec{}, "AccessKey", "Events.Date"))
+	assert.Equal(t, 2, cache.Len())
+
+	assert.Equal(t, "* EXCEPT(RawXML)", build(spec, "-RawXML"))
+	assert.Equal(t, 2, cache.Len())
+
+	// Same projection, but a different computed column.
+	spec.ComputedColumns = map[string]string{"RawXML": "NULL"}
+	assert.Equal(t, "NULL AS RawXML", build(spec, "RawXML"))
+	spec.ComputedColumns = map[string]string{"RawXML": "''"}
+	assert.Equal(t, "'' AS RawXML", build(spec, "RawXML"))
+}
+
+func TestColumnsClauseCacheDoesNotCacheFailures(t *testing.T) {
+	t.Parallel()
+	spec := QueryBuilderSpec{
+		ColumnPolicies: map[string]ColumnPolicy{
+			"RawXML": {Default: ColumnDeny},
+		},
+	}
+	cache := NewColumnsClauseCache(2)
+
+	for i := 0; i < 2; i++ {
+		columns, err := cache.BuildColumnsClause(spec, []string{"AccessKey", "RawXML"})
+		assert.ErrorIs(t, err, ErrColumnDenied)
+		assert.Empty(t, columns)
+	}
+	_, err := cache.BuildColumnsClause(spec, []string{"AccessKey", "A AS"})
+	assert.ErrorIs(t, err, ErrInvalidProjection)
+	assert.Equal(t, 0, cache.Len())
+}
+
+// nfeBenchmarkSpecAndProjection mimics a projection of several hundred fields on a NFe table.
+func nfeBenchmarkSpecAndProjection() (QueryBuilderSpec, []string) {
+	spec := QueryBuilderSpec{
+		RepeatedColumns: map[string]struct{}{
+			"Events":                 {},
+			"NFe.infNFe.det":         {},
+			"NFe.infNFe.det.prod.DI": {},
+			"NFe.infNFe.transp.vol":  {},
+			"NFe.infNFe.cobr.dup":    {},
+		},
+	}
+	prefixes := []string{
+		"NFe.infNFe.ide",
+		"NFe.infNFe.emit.enderEmit",
+		"NFe.infNFe.dest.enderDest",
+		"NFe.infNFe.det.prod",
+		"NFe.infNFe.det.prod.DI",
+		"NFe.infNFe.det.imposto.ICMS.ICMS00",
+		"NFe.infNFe.total.ICMSTot",
+		"NFe.infNFe.transp.vol",
+		"NFe.infNFe.cobr.dup",
+		"Events",
+	}
+	projection := make([]string, 0, len(prefixes)*40)
+	for _, p := range prefixes {
+		for i := 0; i < 40; i++ {
+			projection = append(projection, p+".field"+strconv.Itoa(i))
+		}
+	}
+	return spec, projection
+}
+
+func BenchmarkBuildColumnsClause(b *testing.B) {
+	spec, projection := nfeBenchmarkSpecAndProjection()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		BuildColumnsClause(spec, projection)
+	}
+}
+
+func BenchmarkColumnsClauseCache(b *testing.B) {
+	spec, projection := nfeBenchmarkSpecAndProjection()
+	cache := NewColumnsClauseCache(16)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = cache.BuildColumnsClause(spec, projection)
+	}
+}
+
+func TestBuildColumnsClauseWithOptions(t *testing.T) {
+	t.Parallel()
+	spec := QueryBuilderSpec{
+		RepeatedColumns: map[string]struct{}{
+			"Events":         {},
+			"NFe.infNFe.det": {},
+		},
+	}
+	type eventFilter struct {
+		Types []string `bq:"Type,omitempty"`
+	}
+	lastEvents := ArrayOptions{
+		Filter:  eventFilter{Types: []string{"CANCEL", "CCE"}},
+		OrderBy: []OrderBy{{Column: "Date", Descending: true}},
+		Limit:   10,
+	}
+
+	tests := []struct {
+		name       string
+		projection []string
+		opts       ColumnsClauseOptions
+		expected   string
+		params     []bigquery.QueryParameter
+		wantErr    bool
+	}{
+		{
+			name:       "no options",
+			projection: []string{"AccessKey", "Events.Date"},
+			expected:   "AccessKey,ARRAY(SELECT AS STRUCT Date FROM UNNEST(Events)) AS Events",
+		},
+		{
+			name:       "filter, order and limit",
+			projection: []string{"AccessKey", "Events.Date", "Events.Type"},
+			opts:       ColumnsClauseOptions{Arrays: map[string]ArrayOptions{"Events": lastEvents}},
+			expected: "AccessKey,ARRAY(SELECT AS STRUCT Date,Type FROM UNNEST(Events)" +
+				" WHERE Type IN (@Events_Type0,@Events_Type1) ORDER BY Date DESC LIMIT 10) AS Events",
+			params: []bigquery.QueryParameter{
+				{Name: "Events_Type0", Value: "CANCEL"},
+				{Name: "Events_Type1", Value: "CCE"},
+			},
+		},
+		{
+			name:       "empty filter",
+			projection: []string{"Events.Date"},
+			opts: ColumnsClauseOptions{Arrays: map[string]ArrayOptions{"Events": {
+				Filter: eventFilter{},
+				Limit:  1,
+			}}},
+			expected: "ARRAY(SELECT AS STRUCT Date FROM UNNEST(Events) LIMIT 1) AS Events",
+		},
+		{
+			name:       "array inside struct",
+			projection: []string{"NFe.infNFe.det.prod.CFOP"},
+			opts: ColumnsClauseOptions{Arrays: map[string]ArrayOptions{"NFe.infNFe.det": {
+				OrderBy: []OrderBy{{Column: "prod.CFOP"}, {Column: "_nItem"}},
+			}}},
+			expected: "STRUCT(STRUCT(ARRAY(SELECT AS STRUCT STRUCT(prod.CFOP) AS prod FROM UNNEST(NFe.infNFe.det)" +
+				" ORDER BY prod.CFOP,_nItem) AS det) AS infNFe) AS NFe",
+		},
+		{
+			name:       "array projected as a whole",
+			projection: []string{"Events", "Events AS AllEvents"},
+			opts:       ColumnsClauseOptions{Arrays: map[string]ArrayOptions{"Events": lastEvents}},
+			expected: "ARRAY(SELECT AS STRUCT * FROM UNNEST(Events)" +
+				" WHERE Type IN (@Events_Type0,@Events_Type1) ORDER BY Date DESC LIMIT 10) AS Events," +
+				"ARRAY(SELECT AS STRUCT * FROM UNNEST(Events)" +
+				" WHERE Type IN (@Events_Type0,@Events_Type1) ORDER BY Date DESC LIMIT 10) AS AllEvents",
+			params: []bigquery.QueryParameter{
+				{Name: "Events_Type0", Value: "CANCEL"},
+				{Name: "Events_Type1", Value: "CCE"},
+			},
+		},
+		{
+			name:       "array inside a struct projected as a whole",
+			projection: []string{"AccessKey", "NFe"},
+			opts:       ColumnsClauseOptions{Arrays: map[string]ArrayOptions{"NFe.infNFe.det": {Limit: 5}}},
+			expected: "AccessKey,(SELECT AS STRUCT NFe.* REPLACE((SELECT AS STRUCT NFe.infNFe.* REPLACE(" +
+				"ARRAY(SELECT AS STRUCT * FROM UNNEST(NFe.infNFe.det) LIMIT 5) AS det)) AS infNFe)) AS NFe",
+		},
+		{
+			name:     "whole table",
+			opts:     ColumnsClauseOptions{Arrays: map[string]ArrayOptions{"Events": {Limit: 5}}},
+			expected: "* REPLACE(ARRAY(SELECT AS STRUCT * FROM UNNEST(Events) LIMIT 5) AS Events)",
+		},
+		{
+			name:       "offset",
+			projection: []string{"NFe.infNFe.det.prod.CFOP"},
+			opts: ColumnsClauseOptions{Arrays: map[string]ArrayOptions{"NFe.infNFe.det": {
+				WithOffset: true,
+			}}},
+			expected: "STRUCT(STRUCT(ARRAY(SELECT AS STRUCT Offset,STRUCT(prod.CFOP) AS prod FROM UNNEST(NFe.infNFe.det)" +
+				" WITH OFFSET AS Offset ORDER BY Offset) AS det) AS infNFe) AS NFe",
+		},
+		{
+			name:       "offset with filter, order and limit",
+			projection: []string{"Events.Date"},
+			opts: ColumnsClauseOptions{Arrays: map[string]ArrayOptions{"Events": {
+				Filter:     lastEvents.Filter,
+				OrderBy:    lastEvents.OrderBy,
+				Limit:      lastEvents.Limit,
+				WithOffset: true,
+				OffsetName: "Position",
+			}}},
+			expected: "ARRAY(SELECT AS STRUCT Position,Date FROM UNNEST(Events) WITH OFFSET AS Position" +
+				" WHERE Type IN (@Events_Type0,@Events_Type1) ORDER BY Date DESC,Position LIMIT 10) AS Events",
+			params: []bigquery.QueryParameter{
+				{Name: "Events_Type0", Value: "CANCEL"},
+				{Name: "Events_Type1", Value: "CCE"},
+			},
+		},
+		{
+			name:       "offset of array projected as a whole",
+			projection: []string{"Events", "-Events.Payload"},
+			opts:       ColumnsClauseOptions{Arrays: map[string]ArrayOptions{"Events": {WithOffset: true}}},
+			expected:   "ARRAY(SELECT AS STRUCT * EXCEPT(Payload) FROM UNNEST(Events) WITH OFFSET AS Offset ORDER BY Offset) AS Events",
+		},
+		{
+			name:       "invalid offset name",
+			projection: []string{"Events.Date"},
+			opts:       ColumnsClauseOptions{Arrays: map[string]ArrayOptions{"Events": {WithOffset: true, OffsetName: "a.b"}}},
+			wantErr:    true,
+		},
+		{
+			name:       "flat",
+			projection: []string{"AccessKey", "NFe.infNFe.emit.CNPJ", "NFe.infNFe.emit.xNome AS EmitterName", "NFe.infNFe.ide"},
+			opts:       ColumnsClauseOptions{Flat: true},
+			expected: "AccessKey,NFe.infNFe.emit.CNPJ AS NFe_infNFe_emit_CNPJ," +
+				"NFe.infNFe.emit.xNome AS EmitterName,NFe.infNFe.ide AS NFe_infNFe_ide",
+		},
+		{
+			name:       "flat with separator",
+			projection: []string{"NFe.infNFe.emit.CNPJ", "NFe.Signature", "-NFe.Signature.SignatureValue"},
+			opts:       ColumnsClauseOptions{Flat: true, FlatSeparator: "__"},
+			expected: "NFe.infNFe.emit.CNPJ AS NFe__infNFe__emit__CNPJ," +
+				"(SELECT AS STRUCT NFe.Signature.* EXCEPT(SignatureValue)) AS NFe__Signature",
+		},
+		{
+			name:       "flat with repeated column",
+			projection: []string{"AccessKey", "Events.Date"},
+			opts:       ColumnsClauseOptions{Flat: true},
+			wantErr:    true,
+		},
+		{
+			name:       "flat with repeated column projected as a whole",
+			projection: []string{"AccessKey", "Events"},
+			opts:       ColumnsClauseOptions{Flat: true},
+			wantErr:    true,
+		},
+		{
+			name:       "flat with repeated columns as JSON",
+			projection: []string{"AccessKey", "Events", "NFe.infNFe.det.prod.CFOP", "NFe.infNFe.det._nItem"},
+			opts:       ColumnsClauseOptions{Flat: true, FlatRepeated: FlatRepeatedJSON},
+			expected: "AccessKey,TO_JSON_STRING(Events) AS Events," +
+				"TO_JSON_STRING(ARRAY(SELECT AS STRUCT STRUCT(prod.CFOP) AS prod,_nItem FROM UNNEST(NFe.infNFe.det))) AS NFe_infNFe_det",
+		},
+		{
+			name:       "flat with first element of repeated columns",
+			projection: []string{"Events", "NFe.infNFe.det.prod.CFOP", "NFe.infNFe.det._nItem AS FirstItem"},
+			opts:       ColumnsClauseOptions{Flat: true, FlatRepeated: FlatRepeatedFirst},
+			expected: "Events[SAFE_OFFSET(0)] AS Events," +
+				"NFe.infNFe.det[SAFE_OFFSET(0)].prod.CFOP AS NFe_infNFe_det_prod_CFOP," +
+				"NFe.infNFe.det[SAFE_OFFSET(0)]._nItem AS FirstItem",
+		},
+		{
+			name:       "flat with first element of repeated columns with options",
+			projection: []string{"Events.Date", "Events.Type", "NFe"},
+			opts: ColumnsClauseOptions{
+				Flat:         true,
+				FlatRepeated: FlatRepeatedFirst,
+				Arrays: map[string]ArrayOptions{
+					"Events":         {Filter: lastEvents.Filter, OrderBy: lastEvents.OrderBy, Limit: 10, WithOffset: true},
+					"NFe.infNFe.det": {Limit: 1},
+				},
+			},
+			expected: "(SELECT Offset FROM UNNEST(Events) WITH OFFSET AS Offset" +
+				" WHERE Type IN (@Events_Type0,@Events_Type1) ORDER BY Date DESC,Offset LIMIT 1) AS Events_Offset," +
+				"(SELECT Date FROM UNNEST(Events) WITH OFFSET AS Offset" +
+				" WHERE Type IN (@Events_Type0,@Events_Type1) ORDER BY Date DESC,Offset LIMIT 1) AS Events_Date," +
+				"(SELECT Type FROM UNNEST(Events) WITH OFFSET AS Offset" +
+				" WHERE Type IN (@Events_Type0,@Events_Type1) ORDER BY Date DESC,Offset LIMIT 1) AS Events_Type," +
+				"(SELECT AS STRUCT NFe.* REPLACE((SELECT AS STRUCT NFe.infNFe.* REPLACE(" +
+				"ARRAY(SELECT AS STRUCT * FROM UNNEST(NFe.infNFe.det) LIMIT 1) AS det)) AS infNFe)) AS NFe",
+			params: []bigquery.QueryParameter{
+				{Name: "Events_Type0", Value: "CANCEL"},
+				{Name: "Events_Type1", Value: "CCE"},
+			},
+		},
+		{
+			name:       "flat with first element of array projected as a whole with options",
+			projection: []string{"Events"},
+			opts: ColumnsClauseOptions{
+				Flat:         true,
+				FlatRepeated: FlatRepeatedFirst,
+				Arrays:       map[string]ArrayOptions{"Events": {OrderBy: lastEvents.OrderBy}},
+			},
+			expected: "(ARRAY(SELECT AS STRUCT * FROM UNNEST(Events) ORDER BY Date DESC))[SAFE_OFFSET(0)] AS Events",
+		},
+		{
+			name:    "flat without projection",
+			opts:    ColumnsClauseOptions{Flat: true},
+			wantErr: true,
+		},
+		{
+			name:       "flat with exclusions only",
+			projection: []string{"-RawXML"},
+			opts:       ColumnsClauseOptions{Flat: true},
+			wantErr:    true,
+		},
+		{
+			name:       "flat with invalid separator",
+			projection: []string{"AccessKey"},
+			opts:       ColumnsClauseOptions{Flat: true, FlatSeparator: "."},
+			wantErr:    true,
+		},
+		{
+			name:       "JSON columns",
+			projection: []string{"AccessKey", "NFe.Signature", "NFe.infNFe.det.prod.CFOP", "Events.Date"},
+			opts: ColumnsClauseOptions{
+				JSON: map[string]struct{}{
+					"NFe.Signature":  {},
+					"NFe.infNFe.det": {},
+					"Events":         {},
+				},
+				Arrays: map[string]ArrayOptions{"Events": {Limit: 1}},
+			},
+			expected: "AccessKey,STRUCT(TO_JSON_STRING(NFe.Signature) AS Signature," +
+				"STRUCT(TO_JSON_STRING(ARRAY(SELECT AS STRUCT STRUCT(prod.CFOP) AS prod FROM UNNEST(NFe.infNFe.det))) AS det) AS infNFe) AS NFe," +
+				"TO_JSON_STRING(ARRAY(SELECT AS STRUCT Date FROM UNNEST(Events) LIMIT 1)) AS Events",
+		},
+		{
+			name:       "JSON columns inside columns projected as a whole",
+			projection: []string{"NFe", "-NFe.infNFe.emit"},
+			opts: ColumnsClauseOptions{
+				JSON: map[string]struct{}{
+					"NFe.Signature":  {},
+					"NFe.infNFe.det": {},
+				},
+			},
+			expected: "(SELECT AS STRUCT NFe.* REPLACE(" +
+				"(SELECT AS STRUCT NFe.infNFe.* EXCEPT(emit) REPLACE(TO_JSON_STRING(NFe.infNFe.det) AS det)) AS infNFe," +
+				"TO_JSON_STRING(NFe.Signature) AS Signature)) AS NFe",
+		},
+		{
+			name:     "JSON columns of the whole table",
+			opts:     ColumnsClauseOptions{JSON: map[string]struct{}{"NFe": {}}},
+			expected: "* REPLACE(TO_JSON_STRING(NFe) AS NFe)",
+		},
+		{
+			name:       "flat with JSON columns",
+			projection: []string{"AccessKey", "NFe.Signature.SignatureValue", "NFe.infNFe.det._nItem"},
+			opts: ColumnsClauseOptions{
+				Flat: true,
+				JSON: map[string]struct{}{
+					"NFe.Signature":  {},
+					"NFe.infNFe.det": {},
+				},
+			},
+			expected: "AccessKey,TO_JSON_STRING(STRUCT(NFe.Signature.SignatureValue)) AS NFe_Signature," +
+				"TO_JSON_STRING(ARRAY(SELECT AS STRUCT _nItem FROM UNNEST(NFe.infNFe.det))) AS NFe_infNFe_det",
+		},
+		{
+			name:       "invalid JSON column",
+			projection: []string{"AccessKey"},
+			opts:       ColumnsClauseOptions{JSON: map[string]struct{}{"NFe..Signature": {}}},
+			wantErr:    true,
+		},
+		{
+			name:       "not a repeated column",
+			projection: []string{"NFe.infNFe.emit.CNPJ"},
+			opts:       ColumnsClauseOptions{Arrays: map[string]ArrayOptions{"NFe.infNFe.emit": {Limit: 1}}},
+			wantErr:    true,
+		},
+		{
+			name:       "invalid filter",
+			projection: []string{"Events.Date"},
+			opts:       ColumnsClauseOptions{Arrays: map[string]ArrayOptions{"Events": {Filter: "Type = 1"}}},
+			wantErr:    true,
+		},
+		{
+			name:       "invalid order by",
+			projection: []string{"Events.Date"},
+			opts: ColumnsClauseOptions{Arrays: map[string]ArrayOptions{"Events": {
+				OrderBy: []OrderBy{{Column: "Date; DROP TABLE x"}},
+			}}},
+			wantErr: true,
+		},
+		{
+			name:       "negative limit",
+			projection: []string{"Events.Date"},
+			opts:       ColumnsClauseOptions{Arrays: map[string]ArrayOptions{"Events": {Limit: -1}}},
+			wantErr:    true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			projection := make([]ProjectionField, len(test.projection))
+			for i, p := range test.projection {
+				projection[i] = ParseProjectionField(p)
+			}
+			columns, params, err := BuildColumnsClauseWithOptions(spec, projection, test.opts)
+			if test.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, test.expected, columns)
+			assert.Equal(t, test.params, params)
+		})
+	}
+}
+
+func TestBuildColumnsClauseFlatFirstComputedColumns(t *testing.T) {
+	t.Parallel()
+	spec := QueryBuilderSpec{
+		RepeatedColumns: map[string]struct{}{
+			"NFe.infNFe.det": {},
+		},
+		ComputedColumns: map[string]string{
+			"NFe.infNFe.det.Total": "prod.vProd - prod.vDesc",
+		},
+	}
+	projection := []ProjectionField{
+		{Path: "NFe.infNFe.det._nItem"},
+		{Path: "NFe.infNFe.det.Total"},
+	}
+
+	columns, _, err := BuildColumnsClauseWithOptions(spec, projection, ColumnsClauseOptions{Flat: true, FlatRepeated: FlatRepeatedFirst})
+	assert.NoError(t, err)
+	assert.Equal(t, "NFe.infNFe.det[SAFE_OFFSET(0)]._nItem AS NFe_infNFe_det__nItem,"+
+		"(SELECT prod.vProd - prod.vDesc FROM UNNEST(NFe.infNFe.det) WITH OFFSET o WHERE o = 0) AS NFe_infNFe_det_Total", columns)
+}
+
+func TestBuildColumnsClauseFlatNameCollisions(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name       string
+		projection []string
+		expected   string
+	}{
+		{
+			name:       "nested columns",
+			projection: []string{"a.b_c", "a_b.c"},
+			expected:   "invalid projection: a.b_c and a_b.c are both projected as a_b_c",
+		},
+		{
+			name:       "nested and top level columns",
+			projection: []string{"a.b", "a_b"},
+			expected:   "invalid projection: a.b and a_b are both projected as a_b",
+		},
+		{
+			name:       "alias",
+			projection: []string{"a.b", "c AS A_B"},
+			expected:   "invalid projection: a.b and c are both projected as A_B",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			fields := make([]ProjectionField, len(test.projection))
+			for i, p := range test.projection {
+				fields[i] = ParseProjectionField(p)
+			}
+			_, _, err := BuildColumnsClauseWithOptions(QueryBuilderSpec{}, fields, ColumnsClauseOptions{Flat: true})
+			assert.ErrorIs(t, err, ErrInvalidProjection)
+			assert.EqualError(t, err, test.expected)
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package bigqueryutil
+
+import (
+	"strings"
+
+	"github.com/arquivei/foundationkit/errors"
+)
+
+// validate checks the dedup's columns and mode.
+func (d *DedupSpec) validate() error {
+	if len(d.Keys) == 0 {
+		return errors.Errorf("%w: dedup requires at least one key", ErrInvalidSpec)
+	}
+	for _, k := range d.Keys {
+		if !isValidColumnPath(k) {
+			return errors.Errorf("%w: invalid dedup key %q", ErrInvalidSpec, k)
+		}
+	}
+	if len(d.OrderBy) == 0 {
+		return errors.Errorf("%w: dedup requires the versions to be ordered", ErrInvalidSpec)
+	}
+	for _, o := range d.OrderBy {
+		if !isValidColumnPath(o.Column) {
+			return errors.Errorf("%w: invalid dedup order by column %q", ErrInvalidSpec, o.Column)
+		}
+	}
+	if d.Mode != DedupSubquery && d.Mode != DedupQualify {
+		return errors.Errorf("%w: unknown dedup mode %d", ErrInvalidSpec, d.Mode)
+	}
+	return nil
+}
+
+// queryTemplate returns the query template that keeps the latest version of each key.
+// The filter is applied before the rows are deduplicated, and the order and the limit after.
+func (d *DedupSpec) queryTemplate() string {
+	sb := strings.Builder{}
+	rowNumber := func() {
+		sb.WriteString("ROW_NUMBER() OVER (PARTITION BY ")
+		sb.WriteString(strings.Join(d.Keys, ","))
+		sb.WriteString(" ORDER BY ")
+		for i, o := range d.OrderBy {
+			if i > 0 {
+				sb.WriteString(",")
+			}
+			sb.WriteString(o.String())
+		}
+		sb.WriteString(")")
+	}
+
+	switch d.Mode {
+	case DedupQualify:
+		sb.WriteString("SELECT {{.Columns}} FROM {{.Table}} WHERE {{.Where}} QUALIFY ")
+		rowNumber()
+		sb.WriteString(" = 1")
+	default:
+		sb.WriteString("SELECT * EXCEPT(r) FROM (SELECT {{.Columns}}, ")
+		rowNumber()
+		sb.WriteString(" r FROM {{.Table}} WHERE {{.Where}}) WHERE r = 1")
+	}
+	sb.WriteString("{{with .OrderBy}} ORDER BY {{.}}{{end}}{{with .Limit}} LIMIT {{.}}{{end}}")
+	return sb.String()
+}
@@ -0,0 +1,115 @@
+package bigqueryutil
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedup(t *testing.T) {
+	t.Parallel()
+	type filter struct {
+		Namespace string `bq:",omitempty"`
+	}
+	dedup := DedupSpec{
+		Keys:    []string{"AccessKey", "Owner"},
+		OrderBy: []OrderBy{{Column: "Version", Descending: true}},
+	}
+	qualify := dedup
+	qualify.Mode = DedupQualify
+
+	tests := []struct {
+		name    string
+		dedup   DedupSpec
+		request QueryRequest
+		wantSQL string
+	}{
+		{
+			name:  "subquery",
+			dedup: dedup,
+			request: QueryRequest{
+				Projection: []ProjectionField{{Path: "AccessKey"}},
+				Filter:     filter{Namespace: "tiramissu"},
+			},
+			wantSQL: "SELECT * EXCEPT(r) FROM (SELECT AccessKey, " +
+				"ROW_NUMBER() OVER (PARTITION BY AccessKey,Owner ORDER BY Version DESC) r " +
+				"FROM `dataset.table` WHERE Namespace = @Namespace) WHERE r = 1",
+		},
+		{
+			name:  "subquery with order by and limit",
+			dedup: dedup,
+			request: QueryRequest{
+				OrderBy: []OrderBy{{Column: "CreatedAt"}},
+				Limit:   5,
+			},
+			wantSQL: "SELECT * EXCEPT(r) FROM (SELECT *, " +
+				"ROW_NUMBER() OVER (PARTITION BY AccessKey,Owner ORDER BY Version DESC) r " +
+				"FROM `dataset.table` WHERE TRUE) WHERE r = 1 ORDER BY CreatedAt LIMIT 5",
+		},
+		{
+			name:  "qualify",
+			dedup: qualify,
+			request: QueryRequest{
+				Projection: []ProjectionField{{Path: "AccessKey"}},
+				Filter:     filter{Namespace: "tiramissu"},
+				Limit:      5,
+			},
+			wantSQL: "SELECT AccessKey FROM `dataset.table` WHERE Namespace = @Namespace " +
+				"QUALIFY ROW_NUMBER() OVER (PARTITION BY AccessKey,Owner ORDER BY Version DESC) = 1 LIMIT 5",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			dedup := test.dedup
+			builder, err := NewBuilder(QueryBuilderSpec{Dedup: &dedup}, TableRef{Dataset: "dataset", Table: "table"})
+			assert.NoError(t, err)
+			query, err := builder.Build(context.Background(), test.request)
+			assert.NoError(t, err)
+			assert.Equal(t, test.wantSQL, query.SQL)
+		})
+	}
+}
+
+func TestDedupValidate(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		spec QueryBuilderSpec
+	}{
+		{
+			name: "without keys",
+			spec: QueryBuilderSpec{Dedup: &DedupSpec{OrderBy: []OrderBy{{Column: "Version"}}}},
+		},
+		{
+			name: "without order by",
+			spec: QueryBuilderSpec{Dedup: &DedupSpec{Keys: []string{"AccessKey"}}},
+		},
+		{
+			name: "invalid key",
+			spec: QueryBuilderSpec{Dedup: &DedupSpec{Keys: []string{"Access Key"}, OrderBy: []OrderBy{{Column: "Version"}}}},
+		},
+		{
+			name: "unknown mode",
+			spec: QueryBuilderSpec{Dedup: &DedupSpec{Keys: []string{"AccessKey"}, OrderBy: []OrderBy{{Column: "Version"}}, Mode: 7}},
+		},
+		{
+			name: "with query template",
+			spec: QueryBuilderSpec{
+				QueryTemplate: "SELECT {{.Columns}} FROM {{.Table}} WHERE {{.Where}}",
+				Dedup:         &DedupSpec{Keys: []string{"AccessKey"}, OrderBy: []OrderBy{{Column: "Version"}}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			assert.ErrorIs(t, test.spec.Validate(), ErrInvalidSpec)
+		})
+	}
+}
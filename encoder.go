@@ -22,47 +22,32 @@ import (
 //		IsTaker                 *bool                  	`bq:",omitempty"`
 //	}
 func EncodeBigqueryWhereClause(filter interface{}) (string, []bigquery.QueryParameter, error) {
-	rv := reflect.ValueOf(filter)
-	if rv.Kind() != reflect.Struct {
-		return "", nil, errors.New("filter must be a struct: " + rv.Kind().String())
+	return encodeWhereClause(filter, "")
+}
+
+// encodeWhereClause is like EncodeBigqueryWhereClause, but prefixes the parameters names
+// so they don't collide with the parameters of other clauses of the same query.
+func encodeWhereClause(filter interface{}, paramPrefix string) (string, []bigquery.QueryParameter, error) {
+	fields, err := filterFields(filter)
+	if err != nil {
+		return "", nil, err
 	}
 
 	sb := strings.Builder{}  // main string builder
 	fsb := strings.Builder{} // string builder for a single field
 
-	nFields := rv.Type().NumField()
-
 	// This is an approximation. In reality, TimeRange uses two slots and booleans use none.
-	params := make([]bigquery.QueryParameter, 0, nFields)
-
-	for i := 0; i < nFields; i++ {
-		// Get value, type and tags
-		fvalue := rv.Field(i)
-		ftype := rv.Type().Field(i)
-		fparam := parseFieldParameters(ftype.Tag.Get("bq"))
+	params := make([]bigquery.QueryParameter, 0, len(fields))
 
-		// Skip zero values if omitempty is enabled for the field
-		if fparam.omitEmpty && fvalue.IsZero() {
-			continue
-		}
-
-		// Rename field if specified a new name inside the tag
-		name := ftype.Name
-		if fparam.name != "" {
-			name = fparam.name
-		}
-
-		// Fix kind and value if field is a pointer
-		fkind := ftype.Type.Kind()
-		if fkind == reflect.Ptr {
-			fvalue = fvalue.Elem()
-			fkind = fvalue.Kind()
-		}
+	for _, f := range fields {
+		name := f.name
+		param := paramPrefix + name
+		fvalue := f.value
 
 		fsb.Reset()
 
 		// the filed will be temporary stored
-		if fparam.unnest {
+		if f.unnest {
 			fsb.WriteString("EXISTS (SELECT * FROM UNNEST(")
 			fsb.WriteString(name)
 			fsb.WriteString(") AS x WHERE x")
@@ -70,18 +55,18 @@ func EncodeBigqueryWhereClause(filter interface{}) (string, []bigquery.QueryPara
 			fsb.WriteString(name)
 		}
 
-		switch fkind {
+		switch f.kind {
 		case reflect.String:
 			fsb.WriteString(" = @")
-			fsb.WriteString(name)
-			params = AppendParam(params, name, fvalue.Interface())
+			fsb.WriteString(param)
+			params = AppendParam(params, param, fvalue.Interface())
 		case reflect.Slice:
-			if rv.Field(i).Len() == 0 {
+			if fvalue.Len() == 0 {
 				continue
 			}
 			fsb.WriteString(" IN (")
 			for j := 0; j < fvalue.Len(); j++ {
-				elemName := name + strconv.Itoa(j)
+				elemName := param + strconv.Itoa(j)
 				if j > 0 {
 					fsb.WriteString(",")
 				}
@@ -100,23 +85,19 @@ func EncodeBigqueryWhereClause(filter interface{}) (string, []bigquery.QueryPara
 			switch v := fvalue.Interface().(type) {
 			case TimeRange:
 				fsb.WriteString(" BETWEEN @")
-				fsb.WriteString(name)
+				fsb.WriteString(param)
 				fsb.WriteString("From AND @")
-				fsb.WriteString(name)
+				fsb.WriteString(param)
 				fsb.WriteString("To")
-				format := ftype.Tag.Get("format")
-				if format == "" {
-					format = time.RFC3339
-				}
-				params = AppendParam(params, name+"From", v.From.Format(format))
-				params = AppendParam(params, name+"To", v.To.Format(format))
+				params = AppendParam(params, param+"From", v.From.Format(f.format))
+				params = AppendParam(params, param+"To", v.To.Format(f.format))
 			default:
 				return "", nil, errors.New(name + " struct is not supported")
 			}
 		default:
-			return "", nil, errors.New(name + " is of unknown type: " + fkind.String())
+			return "", nil, errors.New(name + " is of unknown type: " + f.kind.String())
 		}
-		if fparam.unnest {
+		if f.unnest {
 			fsb.WriteString(")")
 		}
 
@@ -129,6 +110,69 @@ func EncodeBigqueryWhereClause(filter interface{}) (string, []bigquery.QueryPara
 	return sb.String(), params, nil
 }
 
+// filterField is a field of a filter struct that takes part in the where clause.
+type filterField struct {
+	// name is the column name, after renaming
+	name string
+	// value and kind are dereferenced if the field is a pointer
+	value  reflect.Value
+	kind   reflect.Kind
+	unnest bool
+	// format is the layout of the TimeRange parameters
+	format string
+}
+
+// filterFields reads the fields of a filter struct, applying their bq tags.
+// It is shared by the where clause encoder and the Matcher, so both read the filter the same way.
+func filterFields(filter interface{}) ([]filterField, error) {
+	rv := reflect.ValueOf(filter)
+	if rv.Kind() != reflect.Struct {
+		return nil, errors.New("filter must be a struct: " + rv.Kind().String())
+	}
+
+	nFields := rv.Type().NumField()
+	fields := make([]filterField, 0, nFields)
+
+	for i := 0; i < nFields; i++ {
+		// Get value, type and tags
+		fvalue := rv.Field(i)
+		ftype := rv.Type().Field(i)
+		fparam := parseFieldParameters(ftype.Tag.Get("bq"))
+
+		// Skip zero values if omitempty is enabled for the field
+		if fparam.omitEmpty && fvalue.IsZero() {
+			continue
+		}
+
+		// Rename field if specified a new name inside the tag
+		name := ftype.Name
+		if fparam.name != "" {
+			name = fparam.name
+		}
+
+		// Fix kind and value if field is a pointer
+		fkind := ftype.Type.Kind()
+		if fkind == reflect.Ptr {
+			fvalue = fvalue.Elem()
+			fkind = fvalue.Kind()
+		}
+
+		format := ftype.Tag.Get("format")
+		if format == "" {
+			format = time.RFC3339
+		}
+
+		fields = append(fields, filterField{
+			name:   name,
+			value:  fvalue,
+			kind:   fkind,
+			unnest: fparam.unnest,
+			format: format,
+		})
+	}
+	return fields, nil
+}
+
 func parseFieldParameters(tag string) fieldParameters {
 	var params fieldParameters
 	if tag == "" {
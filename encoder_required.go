@@ -0,0 +1,108 @@
+package bigqueryutil
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/arquivei/foundationkit/errors"
+)
+
+// ErrMissingRequiredPredicate is returned when the value of a required predicate is missing.
+var ErrMissingRequiredPredicate = errors.New("missing required predicate")
+
+// requiredParamPrefix prefixes the parameters of required predicates, so they don't
+// collide with the parameters of the filter.
+const requiredParamPrefix = "required_"
+
+// EncodeWhereClauseForSpec is like EncodeBigqueryWhereClause, but ANDs the spec's required
+// predicates into the where clause. It returns ErrMissingRequiredPredicate, instead of a
+// where clause, if the value of any required predicate is missing. A nil filter only
+// encodes the required predicates.
+func EncodeWhereClauseForSpec(ctx context.Context, spec QueryBuilderSpec, filter interface{}) (string, []bigquery.QueryParameter, error) {
+	sb := strings.Builder{}
+	params := make([]bigquery.QueryParameter, 0, len(spec.RequiredPredicates))
+
+	for _, p := range spec.RequiredPredicates {
+		if !isValidColumnPath(p.Column) {
+			return "", nil, errors.Errorf("invalid required predicate column %q", p.Column)
+		}
+		if p.Value == nil {
+			return "", nil, errors.Errorf("%w: %s has no value function", ErrMissingRequiredPredicate, p.Column)
+		}
+		value, ok := p.Value(ctx)
+		if rv := reflect.ValueOf(value); rv.Kind() == reflect.Ptr && !rv.IsNil() {
+			value = rv.Elem().Interface()
+		}
+		if !ok || isMissingPredicateValue(value) {
+			return "", nil, errors.Errorf("%w: %s", ErrMissingRequiredPredicate, p.Column)
+		}
+
+		if sb.Len() > 0 {
+			sb.WriteString(" AND ")
+		}
+		params = writeRequiredPredicate(&sb, params, p.Column, value)
+	}
+
+	if filter == nil {
+		return sb.String(), params, nil
+	}
+	where, filterParams, err := EncodeBigqueryWhereClause(filter)
+	if err != nil {
+		return "", nil, err
+	}
+	if where != "" {
+		if sb.Len() > 0 {
+			sb.WriteString(" AND ")
+		}
+		sb.WriteString(where)
+	}
+	return sb.String(), append(params, filterParams...), nil
+}
+
+// writeRequiredPredicate writes the predicate comparing the column to the value.
+func writeRequiredPredicate(
+	sb *strings.Builder,
+	params []bigquery.QueryParameter,
+	column string,
+	value interface{},
+) []bigquery.QueryParameter {
+	param := requiredParamPrefix + strings.ReplaceAll(column, ".", "_")
+	sb.WriteString(column)
+
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Slice || rv.Type().Elem().Kind() == reflect.Uint8 {
+		sb.WriteString(" = @")
+		sb.WriteString(param)
+		return AppendParam(params, param, value)
+	}
+
+	sb.WriteString(" IN (")
+	for i := 0; i < rv.Len(); i++ {
+		elemName := param + strconv.Itoa(i)
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString("@")
+		sb.WriteString(elemName)
+		params = AppendParam(params, elemName, rv.Index(i).Interface())
+	}
+	sb.WriteString(")")
+	return params
+}
+
+// isMissingPredicateValue checks if the value is nil, an empty string or an empty slice.
+func isMissingPredicateValue(value interface{}) bool {
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Invalid:
+		return true
+	case reflect.Ptr, reflect.Interface:
+		return rv.IsNil()
+	case reflect.String, reflect.Slice:
+		return rv.Len() == 0
+	}
+	return false
+}
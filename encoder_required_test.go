@@ -0,0 +1,113 @@
+package bigqueryutil
+
+import (
+	"context"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/arquivei/foundationkit/ref"
+	"github.com/stretchr/testify/assert"
+)
+
+type ownerContextKey struct{}
+
+func TestEncodeWhereClauseForSpec(t *testing.T) {
+	t.Parallel()
+	spec := QueryBuilderSpec{
+		RequiredPredicates: []RequiredPredicate{
+			{Column: "Owner", Value: ContextValue(ownerContextKey{})},
+		},
+	}
+	type filter struct {
+		Namespace string `bq:",omitempty"`
+		IsTaker   *bool  `bq:",omitempty"`
+	}
+
+	tests := []struct {
+		name       string
+		spec       QueryBuilderSpec
+		ctx        context.Context
+		filter     interface{}
+		wantQuery  string
+		wantParams []bigquery.QueryParameter
+		wantErr    error
+	}{
+		{
+			name:      "required predicate and filter",
+			spec:      spec,
+			ctx:       context.WithValue(context.Background(), ownerContextKey{}, "19427033000140"),
+			filter:    filter{Namespace: "tiramissu", IsTaker: ref.Bool(true)},
+			wantQuery: "Owner = @required_Owner AND Namespace = @Namespace AND IsTaker",
+			wantParams: []bigquery.QueryParameter{
+				{Name: "required_Owner", Value: "19427033000140"},
+				{Name: "Namespace", Value: "tiramissu"},
+			},
+		},
+		{
+			name:      "empty filter",
+			spec:      spec,
+			ctx:       context.WithValue(context.Background(), ownerContextKey{}, ref.Of("19427033000140")),
+			filter:    filter{},
+			wantQuery: "Owner = @required_Owner",
+			wantParams: []bigquery.QueryParameter{
+				{Name: "required_Owner", Value: "19427033000140"},
+			},
+		},
+		{
+			name: "nested column and slice value",
+			spec: QueryBuilderSpec{
+				RequiredPredicates: []RequiredPredicate{
+					{Column: "Tenant.Owner", Value: ContextValue(ownerContextKey{})},
+				},
+			},
+			ctx:       context.WithValue(context.Background(), ownerContextKey{}, []string{"a", "b"}),
+			wantQuery: "Tenant.Owner IN (@required_Tenant_Owner0,@required_Tenant_Owner1)",
+			wantParams: []bigquery.QueryParameter{
+				{Name: "required_Tenant_Owner0", Value: "a"},
+				{Name: "required_Tenant_Owner1", Value: "b"},
+			},
+		},
+		{
+			name:       "no required predicates",
+			ctx:        context.Background(),
+			filter:     filter{Namespace: "tiramissu"},
+			wantQuery:  "Namespace = @Namespace",
+			wantParams: []bigquery.QueryParameter{{Name: "Namespace", Value: "tiramissu"}},
+		},
+		{
+			name:    "missing value",
+			spec:    spec,
+			ctx:     context.Background(),
+			filter:  filter{Namespace: "tiramissu"},
+			wantErr: ErrMissingRequiredPredicate,
+		},
+		{
+			name:    "empty value",
+			spec:    spec,
+			ctx:     context.WithValue(context.Background(), ownerContextKey{}, ""),
+			wantErr: ErrMissingRequiredPredicate,
+		},
+		{
+			name:    "empty slice value",
+			spec:    spec,
+			ctx:     context.WithValue(context.Background(), ownerContextKey{}, []string{}),
+			wantErr: ErrMissingRequiredPredicate,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			query, params, err := EncodeWhereClauseForSpec(test.ctx, test.spec, test.filter)
+			if test.wantErr != nil {
+				assert.ErrorIs(t, err, test.wantErr)
+				assert.Empty(t, query)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.wantQuery, query)
+			assert.Equal(t, test.wantParams, params)
+		})
+	}
+}
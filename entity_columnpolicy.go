@@ -0,0 +1,35 @@
+package bigqueryutil
+
+// ColumnAccess defines how a column is projected for a role.
+type ColumnAccess int
+
+const (
+	// ColumnAllow projects the column as is.
+	ColumnAllow ColumnAccess = iota
+	// ColumnDeny refuses to project the column.
+	ColumnDeny
+	// ColumnMaskSHA256 projects the hex encoded SHA256 of the JSON representation of the column under
+	// the column's name, like TO_HEX(SHA256(TO_JSON_STRING(CPF))), so records and arrays are hashed too.
+	// Note the JSON representation of strings is quoted, so the hash of a string column is not the
+	// hash of its value, and it differs from the hash of the value cast to string used before.
+	ColumnMaskSHA256
+	// ColumnMaskNull projects NULL under the column's name.
+	ColumnMaskNull
+)
+
+// ColumnPolicy defines the access to a column and all of its children, unless
+// a child has a policy of its own.
+type ColumnPolicy struct {
+	// Default is the access of the roles that are not listed in Roles.
+	Default ColumnAccess
+	// Roles holds the access of specific roles.
+	Roles map[string]ColumnAccess
+}
+
+// Access returns the access of the role.
+func (p ColumnPolicy) Access(role string) ColumnAccess {
+	if access, ok := p.Roles[role]; ok {
+		return access
+	}
+	return p.Default
+}
@@ -0,0 +1,26 @@
+package bigqueryutil
+
+// DedupMode defines how the latest version of each key is selected.
+type DedupMode int
+
+const (
+	// DedupSubquery numbers the rows in a subquery and keeps the first of each key:
+	//
+	//	SELECT * EXCEPT(r) FROM (SELECT ..., ROW_NUMBER() OVER (PARTITION BY ... ORDER BY ...) r FROM ... WHERE ...) WHERE r = 1
+	DedupSubquery DedupMode = iota
+	// DedupQualify keeps the first row of each key with a QUALIFY clause:
+	//
+	//	SELECT ... FROM ... WHERE ... QUALIFY ROW_NUMBER() OVER (PARTITION BY ... ORDER BY ...) = 1
+	DedupQualify
+)
+
+// DedupSpec defines how a table with many versions of each row is deduplicated,
+// keeping only the latest version of each key.
+type DedupSpec struct {
+	// Keys are the columns that identify a row, like AccessKey and Owner.
+	Keys []string
+	// OrderBy sorts the versions of a row, so the first one is kept, like Version DESC.
+	OrderBy []OrderBy
+	// Mode defines the generated query. Defaults to DedupSubquery.
+	Mode DedupMode
+}
@@ -0,0 +1,41 @@
+package bigqueryutil
+
+import "cloud.google.com/go/bigquery"
+
+// JobConfig holds the configuration of the jobs that run the queries.
+// Zero values are left for BigQuery to decide.
+type JobConfig struct {
+	// Labels are added to the job, like "team": "documents".
+	Labels map[string]string
+	// Location is where the job runs, like "US".
+	Location string
+	// MaxBytesBilled fails the job if it would bill more bytes, if positive.
+	MaxBytesBilled int64
+	// Priority is the priority of the job. Defaults to interactive.
+	Priority bigquery.QueryPriority
+}
+
+// merge returns the config with the fields set by override replacing its own.
+// Labels are merged, with the labels of override winning.
+func (c JobConfig) merge(override JobConfig) JobConfig {
+	if len(override.Labels) > 0 {
+		labels := make(map[string]string, len(c.Labels)+len(override.Labels))
+		for k, v := range c.Labels {
+			labels[k] = v
+		}
+		for k, v := range override.Labels {
+			labels[k] = v
+		}
+		c.Labels = labels
+	}
+	if override.Location != "" {
+		c.Location = override.Location
+	}
+	if override.MaxBytesBilled != 0 {
+		c.MaxBytesBilled = override.MaxBytesBilled
+	}
+	if override.Priority != "" {
+		c.Priority = override.Priority
+	}
+	return c
+}
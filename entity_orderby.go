@@ -0,0 +1,15 @@
+package bigqueryutil
+
+// OrderBy represents a column used to sort the results.
+type OrderBy struct {
+	Column     string
+	Descending bool
+}
+
+// String returns the ORDER BY item, like "Date DESC".
+func (o OrderBy) String() string {
+	if o.Descending {
+		return o.Column + " DESC"
+	}
+	return o.Column
+}
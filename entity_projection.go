@@ -0,0 +1,87 @@
+package bigqueryutil
+
+// ProjectionField represents a single projected column.
+//
+// Path is the dotted column name in the table, like "NFe.infNFe.emit.CNPJ".
+// Alias, if not empty, is the name the column will have in the result, replacing
+// the last segment of the path.
+// Exclude, if true, removes the column from the result instead of projecting it.
+type ProjectionField struct {
+	Path    string
+	Alias   string
+	Exclude bool
+}
+
+// String returns the field in the projection syntax accepted by BuildColumnsClause.
+func (f ProjectionField) String() string {
+	if f.Exclude {
+		return "-" + f.Path
+	}
+	if f.Alias == "" {
+		return f.Path
+	}
+	return f.Path + " AS " + f.Alias
+}
+
+// ColumnsClauseOptions holds the options for BuildColumnsClauseWithOptions.
+type ColumnsClauseOptions struct {
+	// Arrays holds the options of the repeated columns, by their full path, like "NFe.infNFe.det".
+	Arrays map[string]ArrayOptions
+	// JSON holds the columns, by their full path, projected as a single string column with
+	// their JSON representation, like "TO_JSON_STRING(NFe.Signature) AS Signature".
+	// Structs and arrays rebuilt by the projection are converted after being rebuilt.
+	JSON map[string]struct{}
+
+	// Flat, if true, projects every column as a top level column named after its full path,
+	// like "NFe.infNFe.emit.CNPJ AS NFe_infNFe_emit_CNPJ", instead of rebuilding its parent structs.
+	// Aliased columns are named after their alias. Columns projected as a whole are not expanded,
+	// so they are still returned as structs. Columns whose names collide, ignoring case, like
+	// "a.b_c" and "a_b.c", fail with ErrInvalidProjection.
+	Flat bool
+	// FlatSeparator joins the segments of the flat column names. Defaults to DefaultFlatSeparator.
+	FlatSeparator string
+	// FlatRepeated defines how repeated columns are projected in flat mode.
+	FlatRepeated FlatRepeatedMode
+
+	// Role is the role of the caller, used to apply the spec's ColumnPolicies.
+	Role string
+}
+
+// DefaultFlatSeparator is the default separator of the flat column names.
+const DefaultFlatSeparator = "_"
+
+// FlatRepeatedMode defines how repeated columns are projected in flat mode.
+type FlatRepeatedMode int
+
+const (
+	// FlatRepeatedError fails to build the columns clause if a repeated column is projected.
+	FlatRepeatedError FlatRepeatedMode = iota
+	// FlatRepeatedJSON projects the rebuilt array as a single column with its JSON representation,
+	// like "TO_JSON_STRING(ARRAY(SELECT AS STRUCT Date FROM UNNEST(Events))) AS Events".
+	FlatRepeatedJSON
+	// FlatRepeatedFirst projects the columns of the first element of the array, like
+	// "Events[SAFE_OFFSET(0)].Date AS Events_Date". If the array has options, the first element
+	// is the first one that matches the filter, in the given order.
+	FlatRepeatedFirst
+)
+
+// ArrayOptions changes which elements of a repeated column are projected and in which order.
+type ArrayOptions struct {
+	// Filter is a struct encoded like in EncodeBigqueryWhereClause. Its columns are
+	// relative to the elements of the array, like "Date" for "Events.Date".
+	// Only the elements that match the filter are projected.
+	Filter interface{}
+	// OrderBy sorts the elements of the array. Its columns are relative to the elements of the array.
+	OrderBy []OrderBy
+	// Limit, if greater than zero, is the maximum number of elements projected.
+	Limit int
+	// WithOffset adds the position of each element in the stored array as a column of the rebuilt elements.
+	// The elements are also sorted by their position, after any column in OrderBy, so their order is deterministic.
+	WithOffset bool
+	// OffsetName is the name of the offset column. Defaults to DefaultOffsetName.
+	// It must not be the name of a column of the elements.
+	OffsetName string
+}
+
+// DefaultOffsetName is the name of the offset column of arrays projected with ArrayOptions.WithOffset.
+const DefaultOffsetName = "Offset"
@@ -3,5 +3,25 @@ package bigqueryutil
 // QueryBuilderSpec represents the spec for the query builder.
 type QueryBuilderSpec struct {
 	RepeatedColumns map[string]struct{}
-	SQLQuery        string
+	// SQLQuery is the legacy query template, a fmt.Sprintf format whose positional %s verbs are
+	// replaced by the columns clause, the table and the where clause. Prefer QueryTemplate.
+	SQLQuery string
+	// QueryTemplate is the query template with named slots, like
+	// "SELECT {{.Columns}} FROM {{.Table}} WHERE {{.Where}}". See QueryTemplateValues for the slots.
+	QueryTemplate string
+	// Dedup generates a query that keeps only the latest version of each key, with
+	// the {{.OrderBy}} and {{.Limit}} slots. It replaces SQLQuery and QueryTemplate.
+	Dedup *DedupSpec
+	// ComputedColumns holds virtual columns, by their full path, and the expressions that compute them,
+	// like "EventsCount": "ARRAY_LENGTH(Events)". Projecting a virtual column projects its expression
+	// under the last segment of its path, so it may be placed inside structs, like "NFe.infNFe.emit.Doc".
+	// Inside repeated columns the expression is relative to the elements of the array, like
+	// "NFe.infNFe.det.Total": "prod.vProd - prod.vDesc".
+	ComputedColumns map[string]string
+	// ColumnPolicies holds the access policies of columns, by their full path.
+	// A policy also applies to the children of the column, unless they have a policy of their own.
+	// Computed columns must have a policy of their own, as their expressions may read any column.
+	ColumnPolicies map[string]ColumnPolicy
+	// RequiredPredicates are ANDed into every where clause built for the spec by EncodeWhereClauseForSpec.
+	RequiredPredicates []RequiredPredicate
 }
@@ -0,0 +1,30 @@
+package bigqueryutil
+
+import "cloud.google.com/go/bigquery"
+
+// QueryRequest holds what a single query should return.
+type QueryRequest struct {
+	// Projection is the list of projected columns. An empty projection selects all columns.
+	Projection []ProjectionField
+	// Filter is a struct with bq tags, as taken by EncodeBigqueryWhereClause. It may be nil.
+	Filter interface{}
+	// Options are the options of the columns clause.
+	Options ColumnsClauseOptions
+	// OrderBy lists the columns used to sort the results. It requires the {{.OrderBy}} slot.
+	OrderBy []OrderBy
+	// Limit is the maximum number of rows, if positive. It requires the {{.Limit}} slot.
+	Limit int
+}
+
+// BuiltQuery is a query ready to be run by BigQuery.
+type BuiltQuery struct {
+	SQL        string
+	Parameters []bigquery.QueryParameter
+}
+
+// Query returns the client's query with the SQL and the parameters.
+func (q BuiltQuery) Query(client *bigquery.Client) *bigquery.Query {
+	query := client.Query(q.SQL)
+	query.Parameters = q.Parameters
+	return query
+}
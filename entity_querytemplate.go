@@ -0,0 +1,22 @@
+package bigqueryutil
+
+// QueryTemplateValues holds the values of the named slots of a query template.
+// The slots are referenced by the template as {{.Columns}}, {{.Table}}, {{.Where}},
+// {{.OrderBy}} and {{.Limit}}.
+type QueryTemplateValues struct {
+	// Columns is the columns clause, like the one built by BuildColumnsClause. It's required.
+	Columns string
+	// Table is the quoted table reference. It's required.
+	Table string
+	// Where is the where clause, like the one built by EncodeBigqueryWhereClause. It's required,
+	// so the filters and required predicates can't be left out of the query. Templates may use
+	// {{if .Where}}WHERE {{.Where}}{{end}} to omit the WHERE keyword when it's empty.
+	Where string
+	// OrderBy is the comma separated list of ORDER BY items, without the ORDER BY keywords.
+	// It's optional and empty when the query has no ordering, so templates must test it, like
+	// {{with .OrderBy}} ORDER BY {{.}}{{end}}.
+	OrderBy string
+	// Limit is the maximum number of rows, without the LIMIT keyword. It's optional and empty
+	// when the query has no limit, so templates must test it, like {{with .Limit}} LIMIT {{.}}{{end}}.
+	Limit string
+}
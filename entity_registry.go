@@ -0,0 +1,25 @@
+package bigqueryutil
+
+// RegistryEntry holds how an entity, like "nfe", is queried in a schema version.
+type RegistryEntry struct {
+	// Entity is the logical name of the entity, like "nfe".
+	Entity string
+	// Version is the schema version, like "v2".
+	Version string
+	// Default marks the version resolved when no version is requested.
+	// An entity with a single version doesn't need a default.
+	Default bool
+	// Spec is the spec of the entity's queries.
+	Spec QueryBuilderSpec
+	// Table is the table holding the entity.
+	Table TableRef
+	// Defaults are applied to requests that don't set them.
+	Defaults QueryDefaults
+}
+
+// QueryDefaults holds the values used by requests that don't set them.
+type QueryDefaults struct {
+	Projection []ProjectionField
+	OrderBy    []OrderBy
+	Limit      int
+}
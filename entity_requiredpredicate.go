@@ -0,0 +1,25 @@
+package bigqueryutil
+
+import "context"
+
+// RequiredPredicate is a predicate that is ANDed into every where clause built for a spec,
+// like the owner of the rows of a multi-tenant table.
+type RequiredPredicate struct {
+	// Column is the column compared to the value.
+	Column string
+	// Value returns the value of the predicate for the request, and false if it's missing.
+	// Nil values, empty strings and empty slices are also missing. A slice value is
+	// matched with IN, and any other value with equality.
+	Value func(ctx context.Context) (interface{}, bool)
+}
+
+// ContextValue returns a RequiredPredicate value function that reads the value from the context by key.
+func ContextValue(key interface{}) func(ctx context.Context) (interface{}, bool) {
+	return func(ctx context.Context) (interface{}, bool) {
+		v := ctx.Value(key)
+		if v == nil {
+			return nil, false
+		}
+		return v, true
+	}
+}
@@ -0,0 +1,36 @@
+package bigqueryutil
+
+import "cloud.google.com/go/bigquery"
+
+// TableRef references a BigQuery table. The project may be empty, so the table is
+// resolved in the project running the query.
+type TableRef struct {
+	Project string
+	Dataset string
+	Table   string
+}
+
+// TableRefFromTable returns the reference of the table.
+func TableRefFromTable(t *bigquery.Table) TableRef {
+	return TableRef{
+		Project: t.ProjectID,
+		Dataset: t.DatasetID,
+		Table:   t.TableID,
+	}
+}
+
+// String returns the backtick quoted reference, like "`project.dataset.table`".
+func (r TableRef) String() string {
+	if r.Project == "" {
+		return "`" + r.Dataset + "." + r.Table + "`"
+	}
+	return "`" + r.Project + "." + r.Dataset + "." + r.Table + "`"
+}
+
+// BigqueryTable returns the client's handle of the table. The project defaults to the client's project.
+func (r TableRef) BigqueryTable(client *bigquery.Client) *bigquery.Table {
+	if r.Project == "" {
+		return client.Dataset(r.Dataset).Table(r.Table)
+	}
+	return client.DatasetInProject(r.Project, r.Dataset).Table(r.Table)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -16,9 +17,11 @@ func main() {
 			"AccessKey": {},
 			"Owner":     {},
 		},
-		SQLQuery: "SELECT * EXCEPT(r) FROM (SELECT %s, " +
-			"ROW_NUMBER() OVER (PARTITION BY AccessKey, Owner order by Version desc) r " +
-			"FROM %s WHERE %s) WHERE r = 1;",
+		// Dedup keeps only the latest version of each AccessKey and Owner.
+		Dedup: &bigqueryutil.DedupSpec{
+			Keys:    []string{"AccessKey", "Owner"},
+			OrderBy: []bigqueryutil.OrderBy{{Column: "Version", Descending: true}},
+		},
 	}
 
 	// TimeRange represents a time with a beginning and an end.
@@ -45,21 +48,36 @@ func main() {
 	// The projection fields are a required field on the HTTP API.
 	var projectionExample = []string{"AccessKey"}
 
-	// BuildColumnsClause returns the string that represents the columnsClauseBuilder with all columns required
-	// by projection, or "*" if projection is nil.
-	returnedColumns := bigqueryutil.BuildColumnsClause(queryBuilderExample, projectionExample)
+	// Builder glues the columns clause, the where clause and the spec's query together.
+	// TableRef references the table, like "project.dataset.table".
+	tableExample, err := bigqueryutil.ParseTableRef("my-project.dataset.TABLE_EXAMPLE")
+	if err != nil {
+		panic(err)
+	}
+	builderExample, err := bigqueryutil.NewBuilder(queryBuilderExample, tableExample)
+	if err != nil {
+		panic(err)
+	}
+
+	// ParseProjectionField parses a projected column, like "NFe.infNFe.emit.CNPJ AS EmitterCNPJ".
+	fields := make([]bigqueryutil.ProjectionField, len(projectionExample))
+	for i, p := range projectionExample {
+		fields[i] = bigqueryutil.ParseProjectionField(p)
+	}
 
-	// EncodeBigqueryWhereClause transforms a struct into a bigquery's query and parameters list.
-	whereExample, queryParametersExample, err := bigqueryutil.EncodeBigqueryWhereClause(filterExample)
+	// Build returns the SQL query and the Big Query Parameters.
+	queryExample, err := builderExample.Build(context.Background(), bigqueryutil.QueryRequest{
+		Projection: fields,
+		Filter:     filterExample,
+	})
 	if err != nil {
 		panic(err)
 	}
 	// These parameters will be passed to Big Query and will be used in the query.
-	fmt.Printf("Big Query Parameters: \n%+v\n\n", queryParametersExample)
+	fmt.Printf("Big Query Parameters: \n%+v\n\n", queryExample.Parameters)
 
 	// The query is the string that will be used in the BigQuery.
-	sqlQuery := fmt.Sprintf(queryBuilderExample.SQLQuery, returnedColumns, "`TABLE_EXAMPLE`", whereExample)
-	fmt.Printf("Sql Query: \n%+v\n", sqlQuery)
+	fmt.Printf("Sql Query: \n%+v\n", queryExample.SQL)
 
 	//Output:
 	/*
@@ -67,7 +85,7 @@ func main() {
 	   [{Name:Namespace Value:namespace} {Name:CreatedAtFrom Value:2022-01-01T00:00:00Z} {Name:CreatedAtTo Value:2022-02-01T00:00:00Z} {Name:Owner0 Value:owner1} {Name:Owner1 Value:owner2}]
 
 	   Sql Query:
-	   SELECT * EXCEPT(r) FROM (SELECT AccessKey, ROW_NUMBER() OVER (PARTITION BY AccessKey, Owner order by Version desc) r FROM `TABLE_EXAMPLE` WHERE Namespace = @Namespace AND CreatedAt BETWEEN @CreatedAtFrom AND @CreatedAtTo AND Owner IN (@Owner0,@Owner1) AND NOT IsTaker) WHERE r = 1;
+	   SELECT * EXCEPT(r) FROM (SELECT AccessKey, ROW_NUMBER() OVER (PARTITION BY AccessKey,Owner ORDER BY Version DESC) r FROM `my-project.dataset.TABLE_EXAMPLE` WHERE Namespace = @Namespace AND CreatedAt BETWEEN @CreatedAtFrom AND @CreatedAtTo AND Owner IN (@Owner0,@Owner1) AND NOT IsTaker) WHERE r = 1
 	*/
 
 }
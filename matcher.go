@@ -0,0 +1,412 @@
+package bigqueryutil
+
+import (
+	"math/big"
+	"reflect"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"github.com/arquivei/foundationkit/errors"
+)
+
+// RowMatcher checks if a row satisfies a filter. Rows may be structs, with the same bigquery tags
+// and fields promoted from embedded structs used to load query results, or maps from column names to values.
+type RowMatcher func(row interface{}) (bool, error)
+
+// Matcher compiles a filter struct into a RowMatcher, so the filter can be applied to data already in memory.
+// It reads the filter with the same bq tags as EncodeBigqueryWhereClause and follows the SQL semantics of the
+// generated where clause: NULL columns never match, column names are case-insensitive and TimeRange bounds
+// are the formatted parameters, so a format that truncates the bounds truncates them here too.
+// Comparisons that would fail in BigQuery, like a string to a number, fail with an error.
+func Matcher(filter interface{}) (RowMatcher, error) {
+	const op = errors.Op("bigqueryutil.Matcher")
+
+	fields, err := filterFields(filter)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	predicates := make([]fieldPredicate, 0, len(fields))
+	for _, f := range fields {
+		p, ok, err := compileFieldPredicate(f)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+		if ok {
+			predicates = append(predicates, p)
+		}
+	}
+
+	return func(row interface{}) (bool, error) {
+		rv := reflect.ValueOf(row)
+		for _, p := range predicates {
+			ok, err := p(rv)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	}, nil
+}
+
+// fieldPredicate is the compiled condition of a single filter field.
+type fieldPredicate func(row reflect.Value) (bool, error)
+
+// valuePredicate checks a single column value, which is never NULL.
+type valuePredicate func(v reflect.Value) (bool, error)
+
+// compileFieldPredicate compiles the condition of the field. It returns false if the field
+// doesn't take part in the where clause, as empty slices.
+func compileFieldPredicate(f filterField) (fieldPredicate, bool, error) {
+	var match valuePredicate
+
+	switch f.kind {
+	case reflect.String:
+		want := f.value.Interface()
+		match = func(v reflect.Value) (bool, error) {
+			return equalValues(v, reflect.ValueOf(want))
+		}
+	case reflect.Slice:
+		if f.value.Len() == 0 {
+			return nil, false, nil
+		}
+		want := make([]reflect.Value, f.value.Len())
+		for j := range want {
+			want[j] = f.value.Index(j)
+		}
+		match = func(v reflect.Value) (bool, error) {
+			for _, w := range want {
+				ok, err := equalValues(v, w)
+				if err != nil || ok {
+					return ok, err
+				}
+			}
+			return false, nil
+		}
+	case reflect.Bool:
+		if f.unnest && !f.value.Bool() {
+			// The encoder doesn't write a valid clause for it either.
+			return nil, false, errors.New(f.name + " can't unnest a false boolean")
+		}
+		want := f.value.Bool()
+		match = func(v reflect.Value) (bool, error) {
+			if v.Kind() != reflect.Bool {
+				return false, errors.New(f.name + " is not a boolean: " + v.Type().String())
+			}
+			return v.Bool() == want, nil
+		}
+	case reflect.Struct:
+		tr, ok := f.value.Interface().(TimeRange)
+		if !ok {
+			return nil, false, errors.New(f.name + " struct is not supported")
+		}
+		match = betweenPredicate(f.name, tr.From.Format(f.format), tr.To.Format(f.format), f.format)
+	default:
+		return nil, false, errors.New(f.name + " is of unknown type: " + f.kind.String())
+	}
+
+	name := f.name
+	unnest := f.unnest
+	return func(row reflect.Value) (bool, error) {
+		v, err := lookupColumn(row, name)
+		if err != nil {
+			return false, err
+		}
+		if !v.IsValid() {
+			return false, nil
+		}
+		if !unnest {
+			return match(v)
+		}
+		if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+			return false, errors.New(name + " is not a repeated column: " + v.Type().String())
+		}
+		for i := 0; i < v.Len(); i++ {
+			elem := indirect(v.Index(i))
+			if !elem.IsValid() {
+				continue
+			}
+			ok, err := match(elem)
+			if err != nil || ok {
+				return ok, err
+			}
+		}
+		return false, nil
+	}, true, nil
+}
+
+// betweenPredicate matches the values between the formatted bounds, inclusive.
+// BigQuery coerces the string parameters to the type of the column, so the bounds are
+// parsed the same way. String columns are compared to the bounds as strings.
+func betweenPredicate(name, from, to, format string) valuePredicate {
+	fromTime, fromErr := time.Parse(format, from)
+	toTime, toErr := time.Parse(format, to)
+
+	return func(v reflect.Value) (bool, error) {
+		switch c := v.Interface().(type) {
+		case time.Time:
+			if fromErr != nil || toErr != nil {
+				return false, errors.New(name + " bounds are not timestamps: " + from + ", " + to)
+			}
+			return !c.Before(fromTime) && !c.After(toTime), nil
+		case civil.Date:
+			f, errFrom := civil.ParseDate(from)
+			t, errTo := civil.ParseDate(to)
+			if errFrom != nil || errTo != nil {
+				return false, errors.New(name + " bounds are not dates: " + from + ", " + to)
+			}
+			return !c.Before(f) && !c.After(t), nil
+		case civil.DateTime:
+			f, errFrom := parseCivilDateTime(from)
+			t, errTo := parseCivilDateTime(to)
+			if errFrom != nil || errTo != nil {
+				return false, errors.New(name + " bounds are not datetimes: " + from + ", " + to)
+			}
+			return !c.Before(f) && !c.After(t), nil
+		}
+		if v.Kind() == reflect.String {
+			s := v.String()
+			return from <= s && s <= to, nil
+		}
+		return false, errors.New(name + " can't be compared to a time range: " + v.Type().String())
+	}
+}
+
+// parseCivilDateTime parses a datetime, or a date as its midnight.
+func parseCivilDateTime(s string) (civil.DateTime, error) {
+	dt, err := civil.ParseDateTime(s)
+	if err == nil {
+		return dt, nil
+	}
+	d, dateErr := civil.ParseDate(s)
+	if dateErr != nil {
+		return civil.DateTime{}, err
+	}
+	return civil.DateTime{Date: d}, nil
+}
+
+// equalValues compares a column value to a filter value. Numbers of any type are compared by value.
+func equalValues(column, value reflect.Value) (bool, error) {
+	column, value = indirect(column), indirect(value)
+	if !value.IsValid() {
+		return false, nil
+	}
+
+	if a, ok := numericValue(column); ok {
+		b, ok := numericValue(value)
+		if !ok {
+			return false, errors.New("can't compare a number to " + value.Type().String())
+		}
+		return a != nil && b != nil && a.Cmp(b) == 0, nil
+	}
+
+	switch column.Kind() {
+	case reflect.String:
+		if value.Kind() != reflect.String {
+			return false, errors.New("can't compare a string to " + value.Type().String())
+		}
+		return column.String() == value.String(), nil
+	case reflect.Bool:
+		if value.Kind() != reflect.Bool {
+			return false, errors.New("can't compare a boolean to " + value.Type().String())
+		}
+		return column.Bool() == value.Bool(), nil
+	}
+
+	if t, ok := column.Interface().(time.Time); ok {
+		u, ok := value.Interface().(time.Time)
+		if !ok {
+			return false, errors.New("can't compare a timestamp to " + value.Type().String())
+		}
+		return t.Equal(u), nil
+	}
+	if column.Type() != value.Type() || !column.Type().Comparable() {
+		return false, errors.New("can't compare " + column.Type().String() + " to " + value.Type().String())
+	}
+	return column.Interface() == value.Interface(), nil
+}
+
+// numericValue converts integers, floats and NUMERIC values to a rational number.
+// It returns a nil number for NaN and infinities, which are never equal to anything.
+func numericValue(v reflect.Value) (*big.Rat, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return new(big.Rat).SetInt64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return new(big.Rat).SetFrac(new(big.Int).SetUint64(v.Uint()), big.NewInt(1)), true
+	case reflect.Float32, reflect.Float64:
+		return new(big.Rat).SetFloat64(v.Float()), true
+	}
+	switch v.Type() {
+	case reflect.TypeOf(&big.Rat{}):
+		return v.Interface().(*big.Rat), true
+	case reflect.TypeOf(big.Rat{}):
+		if v.CanAddr() {
+			return v.Addr().Interface().(*big.Rat), true
+		}
+		r := v.Interface().(big.Rat)
+		return &r, true
+	}
+	return nil, false
+}
+
+// indirect dereferences pointers and interfaces, returning an invalid value for nil.
+// Pointers to big.Rat are kept, as they hold NUMERIC values.
+func indirect(v reflect.Value) reflect.Value {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		if v.Type() == reflect.TypeOf(&big.Rat{}) {
+			return v
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// lookupColumn finds the column of the row by its path. Nested columns are separated by dots.
+// It returns an invalid value if the column is NULL and an error if the row has no such column.
+func lookupColumn(row reflect.Value, path string) (reflect.Value, error) {
+	v := row
+	for _, name := range strings.Split(path, ".") {
+		v = indirect(v)
+		if !v.IsValid() {
+			return v, nil
+		}
+		var ok bool
+		switch v.Kind() {
+		case reflect.Struct:
+			v, ok = structColumn(v, name)
+		case reflect.Map:
+			if v.Type().Key().Kind() != reflect.String {
+				return reflect.Value{}, errors.New("rows must be structs or maps with string keys: " + v.Type().String())
+			}
+			v, ok = mapColumn(v, name)
+		default:
+			return reflect.Value{}, errors.New("rows must be structs or maps with string keys: " + v.Type().String())
+		}
+		if !ok {
+			return reflect.Value{}, errors.New("unrecognized column: " + path)
+		}
+	}
+	return indirect(v), nil
+}
+
+// structColumn finds the field of the column, by its bigquery tag or its name, preferring an exact
+// match to one ignoring the case. Fields of embedded structs are promoted as when bigquery loads rows.
+// It returns an invalid value if the field is inside a nil embedded pointer.
+func structColumn(v reflect.Value, name string) (reflect.Value, bool) {
+	fields := promotedFields(v.Type())
+	index, ok := fields[name]
+	if !ok {
+		// The first matching column in lexical order is taken, so the match is deterministic.
+		match := ""
+		for column, i := range fields {
+			if strings.EqualFold(column, name) && (!ok || column < match) {
+				index, ok, match = i, true, column
+			}
+		}
+	}
+	if !ok {
+		return reflect.Value{}, false
+	}
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, true
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// promotedFields returns the indexes of the exported fields of the struct by their column names.
+// The fields of untagged embedded structs are promoted as in Go: they are shadowed by the fields
+// of shallower structs, and dropped if ambiguous, unless exactly one of the ambiguous fields is tagged.
+func promotedFields(t reflect.Type) map[string][]int {
+	type field struct {
+		index  []int
+		typ    reflect.Type
+		tagged bool
+	}
+	fields := map[string][]int{}
+	shadowed := map[string]bool{}
+	visited := map[reflect.Type]bool{}
+	level := []field{{typ: t}}
+	for len(level) > 0 {
+		var next []field
+		found := map[string][]field{}
+		for _, embedded := range level {
+			if visited[embedded.typ] {
+				continue
+			}
+			for i := 0; i < embedded.typ.NumField(); i++ {
+				f := embedded.typ.Field(i)
+				tag, _, _ := strings.Cut(f.Tag.Get("bigquery"), ",")
+				if tag == "-" || (!f.IsExported() && !f.Anonymous) {
+					continue
+				}
+				index := append(append([]int(nil), embedded.index...), i)
+				ft := f.Type
+				if ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if f.Anonymous && tag == "" && ft.Kind() == reflect.Struct {
+					next = append(next, field{index: index, typ: ft})
+					continue
+				}
+				if !f.IsExported() {
+					continue
+				}
+				column := f.Name
+				if tag != "" {
+					column = tag
+				}
+				found[column] = append(found[column], field{index: index, tagged: tag != ""})
+			}
+		}
+		for _, embedded := range level {
+			visited[embedded.typ] = true
+		}
+
+		for column, candidates := range found {
+			if shadowed[column] {
+				continue
+			}
+			shadowed[column] = true
+			var tagged []field
+			for _, c := range candidates {
+				if c.tagged {
+					tagged = append(tagged, c)
+				}
+			}
+			switch {
+			case len(candidates) == 1:
+				fields[column] = candidates[0].index
+			case len(tagged) == 1:
+				fields[column] = tagged[0].index
+			}
+		}
+		level = next
+	}
+	return fields
+}
+
+// mapColumn finds the key of the column, ignoring the case if there is no exact match.
+func mapColumn(v reflect.Value, name string) (reflect.Value, bool) {
+	key := reflect.ValueOf(name).Convert(v.Type().Key())
+	if value := v.MapIndex(key); value.IsValid() {
+		return value, true
+	}
+	iter := v.MapRange()
+	for iter.Next() {
+		if strings.EqualFold(iter.Key().String(), name) {
+			return iter.Value(), true
+		}
+	}
+	return reflect.Value{}, false
+}
@@ -0,0 +1,344 @@
+package bigqueryutil
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+	"github.com/arquivei/foundationkit/ref"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type matcherRow struct {
+	Namespace    string
+	Owner        string `bigquery:"owner_cnpj"`
+	OwnerRoles   []string
+	IsTaker      *bool
+	CreatedAt    time.Time
+	EmissionDate civil.Date
+	Total        *big.Rat
+	Items        []int64
+	Emit         struct {
+		CNPJ string
+	} `bigquery:"emit"`
+}
+
+type matcherBase struct {
+	Owner     string
+	Namespace string
+}
+
+type matcherAudit struct {
+	Version string
+}
+
+type matcherEmbeddingRow struct {
+	matcherBase
+	*matcherAudit
+	Namespace string
+}
+
+func TestMatcher(t *testing.T) {
+	t.Parallel()
+	jan := func(day, hour int) time.Time {
+		return time.Date(2022, 1, day, hour, 0, 0, 0, time.UTC)
+	}
+
+	row := matcherRow{
+		Namespace:    "tiramissu",
+		Owner:        "19427033000140",
+		OwnerRoles:   []string{"emitter", "taker"},
+		IsTaker:      ref.Of(true),
+		CreatedAt:    jan(2, 15),
+		EmissionDate: civil.Date{Year: 2022, Month: 1, Day: 2},
+		Total:        big.NewRat(21, 2),
+		Items:        []int64{1, 2},
+	}
+	row.Emit.CNPJ = "03160081000185"
+
+	mapRow := map[string]bigquery.Value{
+		"namespace":  "tiramissu",
+		"owner_cnpj": "19427033000140",
+		"OwnerRoles": []bigquery.Value{"emitter", nil},
+		"IsTaker":    nil,
+		"CreatedAt":  jan(2, 15),
+		"Total":      big.NewRat(21, 2),
+		"emit":       map[string]bigquery.Value{"CNPJ": "03160081000185"},
+	}
+
+	testCases := []struct {
+		name   string
+		filter interface{}
+		// where is the clause generated for the filter, to show what the matcher must agree with
+		where   string
+		row     interface{}
+		want    bool
+		wantErr string
+	}{
+		{
+			name:   "string",
+			filter: struct{ Namespace string }{"tiramissu"},
+			where:  "Namespace = @Namespace",
+			row:    row,
+			want:   true,
+		},
+		{
+			name:   "string mismatch",
+			filter: struct{ Namespace string }{"pudim"},
+			where:  "Namespace = @Namespace",
+			row:    row,
+		},
+		{
+			name: "rename",
+			filter: struct {
+				Owners []string `bq:"owner_cnpj"`
+			}{[]string{"03160081000185", "19427033000140"}},
+			where: "owner_cnpj IN (@owner_cnpj0,@owner_cnpj1)",
+			row:   row,
+			want:  true,
+		},
+		{
+			name: "omitempty",
+			filter: struct {
+				Namespace string   `bq:",omitempty"`
+				Owners    []string `bq:"owner_cnpj,omitempty"`
+			}{},
+			where: "",
+			row:   row,
+			want:  true,
+		},
+		{
+			name: "empty slice",
+			filter: struct {
+				Owners []string `bq:"owner_cnpj"`
+			}{},
+			where: "",
+			row:   row,
+			want:  true,
+		},
+		{
+			name: "unnest",
+			filter: struct {
+				OwnerRoles string `bq:",unnest"`
+			}{"taker"},
+			where: "EXISTS (SELECT * FROM UNNEST(OwnerRoles) AS x WHERE x = @OwnerRoles)",
+			row:   row,
+			want:  true,
+		},
+		{
+			name: "unnest in",
+			filter: struct {
+				OwnerRoles []string `bq:",unnest"`
+			}{[]string{"taker", "carrier"}},
+			where: "EXISTS (SELECT * FROM UNNEST(OwnerRoles) AS x WHERE x IN (@OwnerRoles0,@OwnerRoles1))",
+			row:   mapRow,
+		},
+		{
+			name: "unnest of a column that isn't repeated",
+			filter: struct {
+				Namespace string `bq:",unnest"`
+			}{"tiramissu"},
+			where:   "EXISTS (SELECT * FROM UNNEST(Namespace) AS x WHERE x = @Namespace)",
+			row:     row,
+			wantErr: "Namespace is not a repeated column: string",
+		},
+		{
+			name:   "bool",
+			filter: struct{ IsTaker *bool }{ref.Of(true)},
+			where:  "IsTaker",
+			row:    row,
+			want:   true,
+		},
+		{
+			name:   "not bool",
+			filter: struct{ IsTaker *bool }{ref.Of(false)},
+			where:  "NOT IsTaker",
+			row:    row,
+		},
+		{
+			name:   "not null bool",
+			filter: struct{ IsTaker *bool }{ref.Of(false)},
+			where:  "NOT IsTaker",
+			row:    mapRow,
+		},
+		{
+			name:   "timestamp between",
+			filter: struct{ CreatedAt TimeRange }{TimeRange{From: jan(1, 0), To: jan(2, 15)}},
+			where:  "CreatedAt BETWEEN @CreatedAtFrom AND @CreatedAtTo",
+			row:    row,
+			want:   true,
+		},
+		{
+			name: "format truncates the bounds",
+			filter: struct {
+				CreatedAt TimeRange `format:"2006-01-02"`
+			}{TimeRange{From: jan(1, 0), To: jan(2, 23)}},
+			where: "CreatedAt BETWEEN @CreatedAtFrom AND @CreatedAtTo",
+			row:   mapRow,
+		},
+		{
+			name: "date between",
+			filter: struct {
+				EmissionDate *TimeRange `format:"2006-01-02"`
+			}{&TimeRange{From: jan(2, 23), To: jan(3, 0)}},
+			where: "EmissionDate BETWEEN @EmissionDateFrom AND @EmissionDateTo",
+			row:   row,
+			want:  true,
+		},
+		{
+			name: "date between timestamps",
+			filter: struct {
+				EmissionDate TimeRange
+			}{TimeRange{From: jan(2, 0), To: jan(3, 0)}},
+			where:   "EmissionDate BETWEEN @EmissionDateFrom AND @EmissionDateTo",
+			row:     row,
+			wantErr: "EmissionDate bounds are not dates: 2022-01-02T00:00:00Z, 2022-01-03T00:00:00Z",
+		},
+		{
+			name: "string between",
+			filter: struct {
+				Owner TimeRange `bq:"owner_cnpj"`
+			}{TimeRange{From: jan(2, 0), To: jan(3, 0)}},
+			where: "owner_cnpj BETWEEN @owner_cnpjFrom AND @owner_cnpjTo",
+			row:   row,
+		},
+		{
+			name:   "numeric",
+			filter: struct{ Total []float64 }{[]float64{10.5}},
+			where:  "Total IN (@Total0)",
+			row:    mapRow,
+			want:   true,
+		},
+		{
+			name: "numbers of other types",
+			filter: struct {
+				Items []int32 `bq:",unnest"`
+			}{[]int32{2}},
+			where: "EXISTS (SELECT * FROM UNNEST(Items) AS x WHERE x IN (@Items0))",
+			row:   row,
+			want:  true,
+		},
+		{
+			name: "nested column",
+			filter: struct {
+				CNPJ string `bq:"emit.cnpj"`
+			}{"03160081000185"},
+			where: "emit.cnpj = @emit.cnpj",
+			row:   &mapRow,
+			want:  true,
+		},
+		{
+			name: "all fields must match",
+			filter: struct {
+				Namespace string
+				Owners    []string `bq:"owner_cnpj"`
+			}{"tiramissu", []string{"03160081000185"}},
+			where: "Namespace = @Namespace AND owner_cnpj IN (@owner_cnpj0)",
+			row:   row,
+		},
+		{
+			name:    "type mismatch",
+			filter:  struct{ Total []string }{[]string{"10.5"}},
+			where:   "Total IN (@Total0)",
+			row:     row,
+			wantErr: "can't compare a number to string",
+		},
+		{
+			name:    "unrecognized column",
+			filter:  struct{ Nope string }{"tiramissu"},
+			where:   "Nope = @Nope",
+			row:     row,
+			wantErr: "unrecognized column: Nope",
+		},
+		{
+			name:   "column of an embedded struct",
+			filter: struct{ Owner string }{"19427033000140"},
+			where:  "Owner = @Owner",
+			row:    matcherEmbeddingRow{matcherBase: matcherBase{Owner: "19427033000140"}},
+			want:   true,
+		},
+		{
+			name:   "column shadowing a column of an embedded struct",
+			filter: struct{ Namespace string }{"tiramissu"},
+			where:  "Namespace = @Namespace",
+			row:    matcherEmbeddingRow{matcherBase: matcherBase{Namespace: "pudim"}, Namespace: "tiramissu"},
+			want:   true,
+		},
+		{
+			name:   "column of a nil embedded struct",
+			filter: struct{ Version string }{"v1"},
+			where:  "Version = @Version",
+			row:    matcherEmbeddingRow{},
+			want:   false,
+		},
+		{
+			name:    "rows without column names",
+			filter:  struct{ Namespace string }{"tiramissu"},
+			where:   "Namespace = @Namespace",
+			row:     []bigquery.Value{"tiramissu"},
+			wantErr: "rows must be structs or maps with string keys: []bigquery.Value",
+		},
+	}
+	for _, test := range testCases {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			where, _, err := EncodeBigqueryWhereClause(test.filter)
+			require.NoError(t, err)
+			assert.Equal(t, test.where, where)
+
+			match, err := Matcher(test.filter)
+			require.NoError(t, err)
+			got, err := match(test.row)
+			if test.wantErr != "" {
+				assert.ErrorContains(t, err, test.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestMatcherInvalidFilter(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name    string
+		filter  interface{}
+		wantErr string
+	}{
+		{
+			name:    "not a struct",
+			filter:  "Namespace",
+			wantErr: "filter must be a struct: string",
+		},
+		{
+			name:    "unsupported struct",
+			filter:  struct{ CreatedAt time.Time }{},
+			wantErr: "CreatedAt struct is not supported",
+		},
+		{
+			name:    "unknown type",
+			filter:  struct{ Total int }{},
+			wantErr: "Total is of unknown type: int",
+		},
+		{
+			name: "unnest of a false boolean",
+			filter: struct {
+				IsTaker bool `bq:",unnest"`
+			}{},
+			wantErr: "IsTaker can't unnest a false boolean",
+		},
+	}
+	for _, test := range testCases {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := Matcher(test.filter)
+			assert.ErrorContains(t, err, test.wantErr)
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package bigqueryutil
+
+import (
+	"cloud.google.com/go/bigquery"
+	"github.com/arquivei/foundationkit/errors"
+)
+
+// ProjectionFor returns the projection of all the columns T is decoded from.
+//
+// T must be a struct, or a pointer to a struct, that can be decoded by the bigquery package.
+// The columns are named after the fields' `bigquery` tags, following the same rules as
+// bigquery.InferSchema. Nested structs and slices of structs are walked, so only their
+// leaf columns are projected:
+//
+//	struct {
+//		AccessKey string
+//		Emitter   struct {
+//			CNPJ string
+//		} `bigquery:"emit"`
+//		Events []struct {
+//			Date time.Time
+//		}
+//	}
+//
+// Results in "AccessKey", "emit.CNPJ" and "Events.Date".
+func ProjectionFor[T any]() ([]string, error) {
+	schema, err := schemaFor[T]()
+	if err != nil {
+		return nil, err
+	}
+	return ProjectionForSchema(schema), nil
+}
+
+// RepeatedColumnsFor returns the repeated records of T, the slices of structs, in the
+// format expected by QueryBuilderSpec.RepeatedColumns.
+func RepeatedColumnsFor[T any]() (map[string]struct{}, error) {
+	schema, err := schemaFor[T]()
+	if err != nil {
+		return nil, err
+	}
+	return RepeatedColumnsForSchema(schema), nil
+}
+
+// ProjectionForSchema returns the projection of all the leaf columns of the schema.
+func ProjectionForSchema(schema bigquery.Schema) []string {
+	var projection []string
+	walkSchema(schema, "", func(path string, f *bigquery.FieldSchema) {
+		if f.Type != bigquery.RecordFieldType || len(f.Schema) == 0 {
+			projection = append(projection, path)
+		}
+	})
+	return projection
+}
+
+// RepeatedColumnsForSchema returns the repeated records of the schema, in the
+// format expected by QueryBuilderSpec.RepeatedColumns.
+func RepeatedColumnsForSchema(schema bigquery.Schema) map[string]struct{} {
+	repeated := make(map[string]struct{})
+	walkSchema(schema, "", func(path string, f *bigquery.FieldSchema) {
+		if f.Repeated && f.Type == bigquery.RecordFieldType {
+			repeated[path] = struct{}{}
+		}
+	})
+	return repeated
+}
+
+func schemaFor[T any]() (bigquery.Schema, error) {
+	var zero T
+	schema, err := bigquery.InferSchema(zero)
+	if err != nil {
+		return nil, errors.E(errors.Op("bigqueryutil.schemaFor"), err)
+	}
+	return schema, nil
+}
+
+// walkSchema calls fn for every field of the schema, parents before their children.
+func walkSchema(schema bigquery.Schema, prefix string, fn func(path string, f *bigquery.FieldSchema)) {
+	for _, f := range schema {
+		path := f.Name
+		if prefix != "" {
+			path = prefix + "." + f.Name
+		}
+		fn(path, f)
+		if f.Type == bigquery.RecordFieldType {
+			walkSchema(f.Schema, path, fn)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package bigqueryutil
+
+import (
+	"strings"
+
+	"github.com/arquivei/foundationkit/errors"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// ErrInvalidProjection is returned when a projection can't be parsed.
+var ErrInvalidProjection = errors.New("invalid projection")
+
+// ProjectionFromFieldMask converts a google.protobuf.FieldMask into a projection.
+// Each path of the mask is a dotted column name, like "NFe.infNFe.emit.CNPJ".
+// An empty mask results in an empty projection, that selects all columns.
+func ProjectionFromFieldMask(mask *fieldmaskpb.FieldMask) ([]ProjectionField, error) {
+	paths := mask.GetPaths()
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	projection := make([]ProjectionField, 0, len(paths))
+	for _, p := range paths {
+		if !isValidColumnPath(p) {
+			return nil, errors.Errorf("%w: invalid field mask path %q", ErrInvalidProjection, p)
+		}
+		projection = append(projection, ProjectionField{Path: p})
+	}
+	return projection, nil
+}
+
+// ParseSelection parses a nested selection, like "AccessKey,NFe{infNFe{emit{CNPJ,xNome}}}", into a projection.
+//
+// Fields inside braces are children of the field before the braces, and dotted names
+// are also accepted, so "NFe.infNFe{emit.CNPJ}" selects "NFe.infNFe.emit.CNPJ".
+// A leaf field may be prefixed by an alias, like "EmitterCNPJ:CNPJ", and a field prefixed
+// by a minus sign, like "-Signature", is excluded. A field whose braces only hold
+// exclusions, like "NFe{-Signature}", is projected as a whole without the excluded children.
+func ParseSelection(selection string) ([]ProjectionField, error) {
+	p := selectionParser{in: selection}
+	p.skipSpaces()
+	if p.eof() {
+		return nil, nil
+	}
+	projection, err := p.parseFields("")
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	if !p.eof() {
+		return nil, p.errorf("unexpected %q", p.in[p.pos])
+	}
+	return projection, nil
+}
+
+type selectionParser struct {
+	in  string
+	pos int
+}
+
+// parseFields parses a comma separated list of fields, prefixing their paths with prefix.
+func (p *selectionParser) parseFields(prefix string) ([]ProjectionField, error) {
+	var projection []ProjectionField
+	for {
+		fields, err := p.parseField(prefix)
+		if err != nil {
+			return nil, err
+		}
+		projection = append(projection, fields...)
+
+		p.skipSpaces()
+		if p.eof() || p.in[p.pos] != ',' {
+			return projection, nil
+		}
+		p.pos++
+	}
+}
+
+// parseField parses a single field and its children, if any.
+func (p *selectionParser) parseField(prefix string) ([]ProjectionField, error) {
+	p.skipSpaces()
+	exclude := false
+	if !p.eof() && p.in[p.pos] == '-' {
+		exclude = true
+		p.pos++
+	}
+
+	name, err := p.parseName()
+	if err != nil {
+		return nil, err
+	}
+
+	alias := ""
+	p.skipSpaces()
+	if !exclude && !p.eof() && p.in[p.pos] == ':' {
+		if strings.Contains(name, ".") {
+			return nil, p.errorf("invalid alias %q", name)
+		}
+		p.pos++
+		alias = name
+		if name, err = p.parseName(); err != nil {
+			return nil, err
+		}
+		p.skipSpaces()
+	}
+
+	path := name
+	if prefix != "" {
+		path = prefix + "." + name
+	}
+
+	if exclude || p.eof() || p.in[p.pos] != '{' {
+		return []ProjectionField{{Path: path, Alias: alias, Exclude: exclude}}, nil
+	}
+
+	if alias != "" {
+		return nil, p.errorf("aliases are only supported on leaf fields, but %q has children", name)
+	}
+	p.pos++ // {
+	p.skipSpaces()
+	if !p.eof() && p.in[p.pos] == '}' {
+		return nil, p.errorf("empty selection for %q", name)
+	}
+	children, err := p.parseFields(path)
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	if p.eof() || p.in[p.pos] != '}' {
+		return nil, p.errorf("expected '}' closing %q", name)
+	}
+	p.pos++
+
+	for _, c := range children {
+		if !c.Exclude {
+			return children, nil
+		}
+	}
+	// Only exclusions, so the field itself is projected.
+	return append([]ProjectionField{{Path: path}}, children...), nil
+}
+
+// parseName parses a dotted column name.
+func (p *selectionParser) parseName() (string, error) {
+	p.skipSpaces()
+	start := p.pos
+	for !p.eof() && (isColumnNameChar(p.in[p.pos]) || p.in[p.pos] == '.') {
+		p.pos++
+	}
+	name := p.in[start:p.pos]
+	if name == "" {
+		if p.eof() {
+			return "", p.errorf("unexpected end of selection, expected a field name")
+		}
+		return "", p.errorf("unexpected %q, expected a field name", p.in[p.pos])
+	}
+	if !isValidColumnPath(name) {
+		p.pos = start
+		return "", p.errorf("invalid field name %q", name)
+	}
+	return name, nil
+}
+
+func (p *selectionParser) skipSpaces() {
+	for !p.eof() && (p.in[p.pos] == ' ' || p.in[p.pos] == '\t' || p.in[p.pos] == '\n' || p.in[p.pos] == '\r') {
+		p.pos++
+	}
+}
+
+func (p *selectionParser) eof() bool {
+	return p.pos >= len(p.in)
+}
+
+func (p *selectionParser) errorf(format string, args ...interface{}) error {
+	args = append([]interface{}{ErrInvalidProjection, p.pos}, args...)
+	return errors.Errorf("%w at position %d: "+format, args...)
+}
+
+// Validate checks if the path is made of valid column names and the alias, if any, is a valid column name.
+// Excluded fields can't have an alias.
+func (f ProjectionField) Validate() error {
+	if !isValidColumnPath(f.Path) {
+		return errors.Errorf("%w: invalid column %q", ErrInvalidProjection, f.Path)
+	}
+	if f.Alias == "" {
+		return nil
+	}
+	if f.Exclude {
+		return errors.Errorf("%w: excluded column %s can't have an alias", ErrInvalidProjection, f.Path)
+	}
+	if !isValidColumnName(f.Alias) {
+		return errors.Errorf("%w: invalid alias %q of %s", ErrInvalidProjection, f.Alias, f.Path)
+	}
+	return nil
+}
+
+// isValidColumnPath checks if the path is made of valid column names separated by dots.
+func isValidColumnPath(path string) bool {
+	if path == "" {
+		return false
+	}
+	for _, name := range strings.Split(path, ".") {
+		if !isValidColumnName(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// isValidColumnName checks if the name only has letters, numbers and underscores,
+// and doesn't start with a number.
+func isValidColumnName(name string) bool {
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isColumnNameChar(name[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isColumnNameChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,171 @@
+package bigqueryutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+func TestProjectionFromFieldMask(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		mask     *fieldmaskpb.FieldMask
+		expected []ProjectionField
+		wantErr  bool
+	}{
+		{
+			name: "nil mask",
+		},
+		{
+			name: "empty mask",
+			mask: &fieldmaskpb.FieldMask{},
+		},
+		{
+			name: "paths",
+			mask: &fieldmaskpb.FieldMask{Paths: []string{"AccessKey", "NFe.infNFe.emit.CNPJ", "Events._date"}},
+			expected: []ProjectionField{
+				{Path: "AccessKey"},
+				{Path: "NFe.infNFe.emit.CNPJ"},
+				{Path: "Events._date"},
+			},
+		},
+		{
+			name:    "empty path",
+			mask:    &fieldmaskpb.FieldMask{Paths: []string{"AccessKey", ""}},
+			wantErr: true,
+		},
+		{
+			name:    "empty segment",
+			mask:    &fieldmaskpb.FieldMask{Paths: []string{"NFe..CNPJ"}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid character",
+			mask:    &fieldmaskpb.FieldMask{Paths: []string{"NFe.infNFe;DROP"}},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			projection, err := ProjectionFromFieldMask(test.mask)
+			if test.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidProjection)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, test.expected, projection)
+		})
+	}
+}
+
+func TestParseSelection(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name      string
+		selection string
+		expected  []ProjectionField
+		err       string
+	}{
+		{
+			name:      "empty",
+			selection: "  ",
+		},
+		{
+			name:      "flat fields",
+			selection: "AccessKey, Owner",
+			expected: []ProjectionField{
+				{Path: "AccessKey"},
+				{Path: "Owner"},
+			},
+		},
+		{
+			name:      "nested fields",
+			selection: "NFe{infNFe{emit{CNPJ,xNome}}},AccessKey",
+			expected: []ProjectionField{
+				{Path: "NFe.infNFe.emit.CNPJ"},
+				{Path: "NFe.infNFe.emit.xNome"},
+				{Path: "AccessKey"},
+			},
+		},
+		{
+			name:      "dotted names, aliases and whitespace",
+			selection: "NFe.infNFe {\n\temit { EmitterCNPJ: CNPJ },\n\tdet.prod { CFOP }\n}",
+			expected: []ProjectionField{
+				{Path: "NFe.infNFe.emit.CNPJ", Alias: "EmitterCNPJ"},
+				{Path: "NFe.infNFe.det.prod.CFOP"},
+			},
+		},
+		{
+			name:      "exclusions",
+			selection: "-RawXML,NFe{-Signature,-infNFe.det},Events{Date,-Payload}",
+			expected: []ProjectionField{
+				{Path: "RawXML", Exclude: true},
+				{Path: "NFe"},
+				{Path: "NFe.Signature", Exclude: true},
+				{Path: "NFe.infNFe.det", Exclude: true},
+				{Path: "Events.Date"},
+				{Path: "Events.Payload", Exclude: true},
+			},
+		},
+		{
+			name:      "unclosed braces",
+			selection: "NFe{infNFe{emit{CNPJ}}",
+			err:       "invalid projection at position 22: expected '}' closing \"NFe\"",
+		},
+		{
+			name:      "empty braces",
+			selection: "NFe{}",
+			err:       "invalid projection at position 4: empty selection for \"NFe\"",
+		},
+		{
+			name:      "missing field",
+			selection: "AccessKey,,Owner",
+			err:       "invalid projection at position 10: unexpected ',', expected a field name",
+		},
+		{
+			name:      "trailing comma",
+			selection: "AccessKey,",
+			err:       "invalid projection at position 10: unexpected end of selection, expected a field name",
+		},
+		{
+			name:      "unexpected closing brace",
+			selection: "AccessKey}",
+			err:       "invalid projection at position 9: unexpected '}'",
+		},
+		{
+			name:      "alias on struct",
+			selection: "Emitter:emit{CNPJ}",
+			err:       "invalid projection at position 12: aliases are only supported on leaf fields, but \"emit\" has children",
+		},
+		{
+			name:      "invalid name",
+			selection: "NFe{infNFe..emit}",
+			err:       "invalid projection at position 4: invalid field name \"infNFe..emit\"",
+		},
+		{
+			name:      "invalid character",
+			selection: "NFe{infNFe;DROP}",
+			err:       "invalid projection at position 10: expected '}' closing \"NFe\"",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			projection, err := ParseSelection(test.selection)
+			if test.err != "" {
+				assert.ErrorIs(t, err, ErrInvalidProjection)
+				assert.EqualError(t, err, test.err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, test.expected, projection)
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package bigqueryutil
+
+import (
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
+	"github.com/stretchr/testify/assert"
+)
+
+type projectionTestDocument struct {
+	AccessKey string
+	Owner     string `bigquery:"Owner"`
+	Ignored   string `bigquery:"-"`
+	NFe       struct {
+		InfNFe struct {
+			Emitter struct {
+				CNPJ  string
+				XNome bigquery.NullString `bigquery:"xNome"`
+			} `bigquery:"emit"`
+			Items []struct {
+				Number int64 `bigquery:"_nItem"`
+				Prod   struct {
+					CFOP string
+					DI   []struct {
+						NDI string `bigquery:"nDI"`
+					}
+				} `bigquery:"prod"`
+			} `bigquery:"det"`
+		} `bigquery:"infNFe"`
+	}
+	Events []*struct {
+		Date time.Time
+	}
+	EmissionDate civil.Date
+	OwnerRoles   []string
+	projectionTestEmbedded
+}
+
+type projectionTestEmbedded struct {
+	Version int64
+}
+
+func TestProjectionFor(t *testing.T) {
+	t.Parallel()
+
+	projection, err := ProjectionFor[projectionTestDocument]()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"AccessKey",
+		"Owner",
+		"NFe.infNFe.emit.CNPJ",
+		"NFe.infNFe.emit.xNome",
+		"NFe.infNFe.det._nItem",
+		"NFe.infNFe.det.prod.CFOP",
+		"NFe.infNFe.det.prod.DI.nDI",
+		"Events.Date",
+		"EmissionDate",
+		"OwnerRoles",
+		"Version",
+	}, projection)
+
+	pointerProjection, err := ProjectionFor[*projectionTestDocument]()
+	assert.NoError(t, err)
+	assert.Equal(t, projection, pointerProjection)
+
+	_, err = ProjectionFor[string]()
+	assert.Error(t, err)
+}
+
+func TestRepeatedColumnsFor(t *testing.T) {
+	t.Parallel()
+
+	repeated, err := RepeatedColumnsFor[projectionTestDocument]()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]struct{}{
+		"NFe.infNFe.det":         {},
+		"NFe.infNFe.det.prod.DI": {},
+		"Events":                 {},
+	}, repeated)
+
+	_, err = RepeatedColumnsFor[struct{ Invalid uintptr }]()
+	assert.Error(t, err)
+}
+
+func TestProjectionForWithBuildColumnsClause(t *testing.T) {
+	t.Parallel()
+
+	type row struct {
+		AccessKey string
+		Events    []struct {
+			Date time.Time
+		}
+	}
+	projection, err := ProjectionFor[row]()
+	assert.NoError(t, err)
+	repeated, err := RepeatedColumnsFor[row]()
+	assert.NoError(t, err)
+
+	assert.Equal(t,
+		"AccessKey,ARRAY(SELECT AS STRUCT Date FROM UNNEST(Events)) AS Events",
+		BuildColumnsClause(QueryBuilderSpec{RepeatedColumns: repeated}, projection),
+	)
+}
@@ -0,0 +1,95 @@
+package bigqueryutil
+
+import (
+	"context"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// Querier runs built queries. Application code should depend on it, instead of on
+// *bigquery.Client, so it can be faked in tests.
+type Querier interface {
+	// Query runs the query with the job config and returns an iterator over its rows.
+	Query(ctx context.Context, q BuiltQuery, cfg JobConfig) (RowIterator, error)
+}
+
+// RowIterator iterates over the rows of a query.
+type RowIterator interface {
+	// Next loads the next row into dst, as *bigquery.RowIterator does. It returns
+	// iterator.Done when there are no more rows.
+	Next(dst interface{}) error
+	// Schema returns the schema of the rows. It may be nil until the first call to Next.
+	Schema() bigquery.Schema
+	// TotalRows returns the number of rows of the result. It may be zero until the first call to Next.
+	TotalRows() uint64
+	// Close releases the iterator. It must be called when the rows are no longer needed.
+	Close() error
+}
+
+// ClientQuerier is the Querier that runs queries with a *bigquery.Client.
+type ClientQuerier struct {
+	client   *bigquery.Client
+	defaults JobConfig
+}
+
+// NewClientQuerier returns a Querier that runs queries with the client. The defaults
+// are used by every query, unless replaced by the job config of the query.
+func NewClientQuerier(client *bigquery.Client, defaults JobConfig) *ClientQuerier {
+	return &ClientQuerier{client: client, defaults: defaults}
+}
+
+// Query implements Querier. It waits for the job to finish before returning the iterator,
+// and cancels the job if waiting fails, like when the context is done.
+func (c *ClientQuerier) Query(ctx context.Context, q BuiltQuery, cfg JobConfig) (RowIterator, error) {
+	query := c.newQuery(q, cfg)
+
+	ctx, cancel := context.WithCancel(ctx)
+	job, err := query.Run(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	it, err := job.Read(ctx)
+	if err != nil {
+		cancel()
+		_ = job.Cancel(context.Background())
+		return nil, err
+	}
+	return &clientRowIterator{it: it, cancel: cancel}, nil
+}
+
+// newQuery returns the client's query with the SQL, the parameters and the job config.
+func (c *ClientQuerier) newQuery(q BuiltQuery, cfg JobConfig) *bigquery.Query {
+	cfg = c.defaults.merge(cfg)
+	query := q.Query(c.client)
+	query.Labels = cfg.Labels
+	query.Location = cfg.Location
+	query.MaxBytesBilled = cfg.MaxBytesBilled
+	query.Priority = cfg.Priority
+	return query
+}
+
+// clientRowIterator is the RowIterator of a *bigquery.RowIterator.
+type clientRowIterator struct {
+	it     *bigquery.RowIterator
+	cancel context.CancelFunc
+}
+
+func (i *clientRowIterator) Next(dst interface{}) error {
+	return i.it.Next(dst)
+}
+
+func (i *clientRowIterator) Schema() bigquery.Schema {
+	return i.it.Schema
+}
+
+func (i *clientRowIterator) TotalRows() uint64 {
+	return i.it.TotalRows
+}
+
+func (i *clientRowIterator) Close() error {
+	// The job is done once Job.Read returns, so there is nothing to cancel but the
+	// context of the page requests.
+	i.cancel()
+	return nil
+}
@@ -0,0 +1,45 @@
+package bigqueryutil
+
+import (
+	"context"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/option"
+)
+
+func TestClientQuerierNewQuery(t *testing.T) {
+	t.Parallel()
+	client, err := bigquery.NewClient(context.Background(), "my-project",
+		option.WithoutAuthentication(), option.WithEndpoint("http://localhost"))
+	assert.NoError(t, err)
+	defer client.Close()
+
+	querier := NewClientQuerier(client, JobConfig{
+		Labels:         map[string]string{"team": "documents", "service": "api"},
+		Location:       "US",
+		MaxBytesBilled: 1 << 30,
+	})
+	built := BuiltQuery{
+		SQL:        "SELECT AccessKey FROM `documents.nfe` WHERE Owner = @Owner",
+		Parameters: []bigquery.QueryParameter{{Name: "Owner", Value: "19427033000140"}},
+	}
+
+	query := querier.newQuery(built, JobConfig{
+		Labels:   map[string]string{"service": "export"},
+		Priority: bigquery.BatchPriority,
+	})
+	assert.Equal(t, built.SQL, query.Q)
+	assert.Equal(t, built.Parameters, query.Parameters)
+	assert.Equal(t, map[string]string{"team": "documents", "service": "export"}, query.Labels)
+	assert.Equal(t, "US", query.Location)
+	assert.Equal(t, int64(1<<30), query.MaxBytesBilled)
+	assert.Equal(t, bigquery.BatchPriority, query.Priority)
+
+	query = querier.newQuery(built, JobConfig{Location: "EU", MaxBytesBilled: 1 << 20})
+	assert.Equal(t, map[string]string{"team": "documents", "service": "api"}, query.Labels)
+	assert.Equal(t, "EU", query.Location)
+	assert.Equal(t, int64(1<<20), query.MaxBytesBilled)
+	assert.Equal(t, bigquery.QueryPriority(""), query.Priority)
+}
@@ -0,0 +1,49 @@
+package bigqueryutil
+
+import (
+	"context"
+	stderrors "errors"
+	"iter"
+
+	"google.golang.org/api/iterator"
+)
+
+// Query runs the query with the querier and iterates over its rows, decoded into T.
+//
+// Rows are decoded as by *bigquery.RowIterator: T may be a struct, a bigquery.ValueLoader
+// pointer target, []bigquery.Value or map[string]bigquery.Value. Every row is decoded into
+// a new T, so the fields that aren't projected by the query stay zero.
+//
+// Failures are yielded as errors, and end the iteration. The iterator is closed when the
+// iteration ends, including when the consumer stops early.
+func Query[T any](ctx context.Context, querier Querier, q BuiltQuery) iter.Seq2[T, error] {
+	return QueryWithConfig[T](ctx, querier, q, JobConfig{})
+}
+
+// QueryWithConfig is like Query, but runs the query with the job config.
+func QueryWithConfig[T any](ctx context.Context, querier Querier, q BuiltQuery, cfg JobConfig) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+		it, err := querier.Query(ctx, q, cfg)
+		if err != nil {
+			yield(zero, err)
+			return
+		}
+		defer it.Close()
+
+		for {
+			var row T
+			err := it.Next(&row)
+			if stderrors.Is(err, iterator.Done) {
+				return
+			}
+			if err != nil {
+				yield(zero, err)
+				return
+			}
+			if !yield(row, nil) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package bigqueryutil
+
+import (
+	"context"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/arquivei/foundationkit/errors"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/iterator"
+)
+
+// sliceQuerier is a Querier that returns rows from a slice.
+type sliceQuerier struct {
+	schema bigquery.Schema
+	rows   [][]bigquery.Value
+	err    error
+	// closed counts the closed iterators
+	closed int
+}
+
+func (q *sliceQuerier) Query(_ context.Context, _ BuiltQuery, _ JobConfig) (RowIterator, error) {
+	return &sliceRowIterator{querier: q}, nil
+}
+
+type sliceRowIterator struct {
+	querier *sliceQuerier
+	next    int
+}
+
+func (i *sliceRowIterator) Next(dst interface{}) error {
+	if i.next == len(i.querier.rows) {
+		if i.querier.err != nil {
+			return i.querier.err
+		}
+		return iterator.Done
+	}
+	row := i.querier.rows[i.next]
+	i.next++
+	return dst.(bigquery.ValueLoader).Load(row, i.querier.schema)
+}
+
+func (i *sliceRowIterator) Schema() bigquery.Schema { return i.querier.schema }
+func (i *sliceRowIterator) TotalRows() uint64       { return uint64(len(i.querier.rows)) }
+func (i *sliceRowIterator) Close() error {
+	i.querier.closed++
+	return nil
+}
+
+type accessKeyRow struct {
+	AccessKey string
+	Owner     string
+}
+
+func (r *accessKeyRow) Load(values []bigquery.Value, schema bigquery.Schema) error {
+	for i, f := range schema {
+		switch f.Name {
+		case "AccessKey":
+			r.AccessKey = values[i].(string)
+		case "Owner":
+			r.Owner = values[i].(string)
+		}
+	}
+	return nil
+}
+
+func TestQuery(t *testing.T) {
+	t.Parallel()
+	schema := bigquery.Schema{{Name: "AccessKey", Type: bigquery.StringFieldType}}
+	rows := [][]bigquery.Value{{"a"}, {"b"}, {"c"}}
+
+	t.Run("all rows", func(t *testing.T) {
+		t.Parallel()
+		querier := &sliceQuerier{schema: schema, rows: rows}
+		var got []accessKeyRow
+		for row, err := range Query[accessKeyRow](context.Background(), querier, BuiltQuery{}) {
+			assert.NoError(t, err)
+			got = append(got, row)
+		}
+		// Owner isn't projected, so it stays zero.
+		assert.Equal(t, []accessKeyRow{{AccessKey: "a"}, {AccessKey: "b"}, {AccessKey: "c"}}, got)
+		assert.Equal(t, 1, querier.closed)
+	})
+
+	t.Run("early stop", func(t *testing.T) {
+		t.Parallel()
+		querier := &sliceQuerier{schema: schema, rows: rows}
+		for row, err := range Query[accessKeyRow](context.Background(), querier, BuiltQuery{}) {
+			assert.NoError(t, err)
+			assert.Equal(t, "a", row.AccessKey)
+			break
+		}
+		assert.Equal(t, 1, querier.closed)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		t.Parallel()
+		failure := errors.New("failure")
+		querier := &sliceQuerier{schema: schema, rows: rows[:1], err: failure}
+		var errs []error
+		n := 0
+		for _, err := range Query[accessKeyRow](context.Background(), querier, BuiltQuery{}) {
+			n++
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+		assert.Equal(t, 2, n)
+		assert.Equal(t, []error{failure}, errs)
+		assert.Equal(t, 1, querier.closed)
+	})
+}
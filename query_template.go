@@ -0,0 +1,173 @@
+package bigqueryutil
+
+import (
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/arquivei/foundationkit/errors"
+)
+
+// ErrInvalidQueryTemplate is returned when a query template can't be parsed, uses unknown slots
+// or lacks required slots.
+var ErrInvalidQueryTemplate = errors.New("invalid query template")
+
+// queryTemplateSlot checks if the slot is known and whether it is required.
+func queryTemplateSlot(slot string) (known bool, required bool) {
+	switch slot {
+	case "Columns", "Table", "Where":
+		return true, true
+	case "OrderBy", "Limit":
+		return true, false
+	}
+	return false, false
+}
+
+// requiredQueryTemplateSlots returns the slots every template must use.
+func requiredQueryTemplateSlots() []string {
+	return []string{"Columns", "Table", "Where"}
+}
+
+// QueryTemplate is a parsed query template, like
+// "SELECT {{.Columns}} FROM {{.Table}} WHERE {{.Where}}".
+type QueryTemplate struct {
+	tmpl  *template.Template
+	slots map[string]struct{}
+}
+
+// ParseQueryTemplate parses and validates a query template. Every slot used by the template
+// must be known, and the required slots, Columns, Table and Where, must be present. The
+// optional slots, OrderBy and Limit, must be tested by an enclosing {{if}} or {{with}}, like
+// "{{with .OrderBy}} ORDER BY {{.}}{{end}}", as they are empty when the query doesn't set them.
+func ParseQueryTemplate(text string) (*QueryTemplate, error) {
+	tmpl, err := template.New("query").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, errors.Errorf("%w: %s", ErrInvalidQueryTemplate, strings.TrimPrefix(err.Error(), "template: "))
+	}
+
+	t := &QueryTemplate{tmpl: tmpl, slots: map[string]struct{}{}}
+	if err := t.collectSlots(tmpl.Root, slotScope{}); err != nil {
+		return nil, err
+	}
+	for _, slot := range requiredQueryTemplateSlots() {
+		if _, ok := t.slots[slot]; !ok {
+			return nil, errors.Errorf("%w: missing required slot {{.%s}}", ErrInvalidQueryTemplate, slot)
+		}
+	}
+	return t, nil
+}
+
+// Uses checks if the template uses the slot, like "OrderBy".
+func (t *QueryTemplate) Uses(slot string) bool {
+	_, ok := t.slots[slot]
+	return ok
+}
+
+// Execute returns the query with the slots replaced by the values.
+func (t *QueryTemplate) Execute(values QueryTemplateValues) (string, error) {
+	sb := strings.Builder{}
+	if err := t.tmpl.Execute(&sb, values); err != nil {
+		return "", errors.Errorf("%w: %s", ErrInvalidQueryTemplate, strings.TrimPrefix(err.Error(), "template: "))
+	}
+	return sb.String(), nil
+}
+
+// slotScope is where a node of the template is, as seen by collectSlots.
+type slotScope struct {
+	// guarded holds the optional slots tested by the enclosing if and with blocks.
+	guarded map[string]bool
+	// cond is set in the conditions of if and with blocks, which may test optional slots.
+	cond bool
+}
+
+// collectSlots walks the parse tree, collecting the used slots and failing on unknown ones.
+// Optional slots may be empty, so they must be tested by an enclosing if or with block.
+func (t *QueryTemplate) collectSlots(node parse.Node, scope slotScope) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, c := range n.Nodes {
+			if err := t.collectSlots(c, scope); err != nil {
+				return err
+			}
+		}
+	case *parse.ActionNode:
+		return t.collectSlots(n.Pipe, scope)
+	case *parse.PipeNode:
+		if n == nil {
+			return nil
+		}
+		for _, c := range n.Cmds {
+			if err := t.collectSlots(c, scope); err != nil {
+				return err
+			}
+		}
+	case *parse.CommandNode:
+		for _, a := range n.Args {
+			if err := t.collectSlots(a, scope); err != nil {
+				return err
+			}
+		}
+	case *parse.IfNode:
+		return t.collectBranchSlots(&n.BranchNode, scope, true)
+	case *parse.RangeNode:
+		return t.collectBranchSlots(&n.BranchNode, scope, false)
+	case *parse.WithNode:
+		return t.collectBranchSlots(&n.BranchNode, scope, true)
+	case *parse.FieldNode:
+		slot := n.Ident[0]
+		known, required := queryTemplateSlot(slot)
+		if !known {
+			return errors.Errorf("%w: unknown slot {{.%s}}", ErrInvalidQueryTemplate, slot)
+		}
+		if len(n.Ident) > 1 {
+			return errors.Errorf("%w: slot {{.%s}} has no field %s", ErrInvalidQueryTemplate, slot, strings.Join(n.Ident[1:], "."))
+		}
+		if !required && !scope.cond && !scope.guarded[slot] {
+			return errors.Errorf("%w: optional slot {{.%s}} must be inside {{if .%s}} or {{with .%s}}",
+				ErrInvalidQueryTemplate, slot, slot, slot)
+		}
+		t.slots[slot] = struct{}{}
+	case *parse.VariableNode:
+		// $.Slot refers to the slots from inside range and with blocks.
+		if len(n.Ident) > 1 && n.Ident[0] == "$" {
+			return t.collectSlots(&parse.FieldNode{Ident: n.Ident[1:]}, scope)
+		}
+	case *parse.TemplateNode:
+		return errors.Errorf("%w: nested templates are not supported", ErrInvalidQueryTemplate)
+	}
+	return nil
+}
+
+// collectBranchSlots collects the slots of an if, range or with block. The slots tested by
+// the condition of a guard block are guarded in its list, but not in its else list.
+func (t *QueryTemplate) collectBranchSlots(n *parse.BranchNode, scope slotScope, guard bool) error {
+	cond := &QueryTemplate{slots: map[string]struct{}{}}
+	if err := cond.collectSlots(n.Pipe, slotScope{guarded: scope.guarded, cond: guard || scope.cond}); err != nil {
+		return err
+	}
+	list := slotScope{guarded: scope.guarded}
+	if guard {
+		list.guarded = make(map[string]bool, len(scope.guarded)+len(cond.slots))
+		for slot := range scope.guarded {
+			list.guarded[slot] = true
+		}
+	}
+	for slot := range cond.slots {
+		t.slots[slot] = struct{}{}
+		if guard {
+			list.guarded[slot] = true
+		}
+	}
+	if err := t.collectSlots(n.List, list); err != nil {
+		return err
+	}
+	return t.collectSlots(n.ElseList, slotScope{guarded: scope.guarded})
+}
+
+// parsedQueryTemplate parses the spec's query template. Builders parse it once, when they are created.
+func (s QueryBuilderSpec) parsedQueryTemplate() (*QueryTemplate, error) {
+	return ParseQueryTemplate(s.queryTemplateText())
+}
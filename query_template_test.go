@@ -0,0 +1,182 @@
+package bigqueryutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseQueryTemplate(t *testing.T) {
+	t.Parallel()
+	values := QueryTemplateValues{
+		Columns: "AccessKey",
+		Table:   "`project.dataset.table`",
+		Where:   "Owner = @Owner",
+		OrderBy: "CreatedAt DESC",
+		Limit:   "10",
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+		wantErr  string
+	}{
+		{
+			name:     "required slots",
+			template: "SELECT {{.Columns}} FROM {{.Table}} WHERE {{.Where}}",
+			expected: "SELECT AccessKey FROM `project.dataset.table` WHERE Owner = @Owner",
+		},
+		{
+			name: "all slots",
+			template: "SELECT {{.Columns}} FROM {{.Table}}{{if .Where}} WHERE {{.Where}}{{end}}" +
+				"{{with .OrderBy}} ORDER BY {{.}}{{end}}{{with .Limit}} LIMIT {{.}}{{end}}",
+			expected: "SELECT AccessKey FROM `project.dataset.table` WHERE Owner = @Owner ORDER BY CreatedAt DESC LIMIT 10",
+		},
+		{
+			name: "guarded optional slots",
+			template: "SELECT {{.Columns}} FROM {{.Table}} WHERE {{.Where}}" +
+				"{{if and .OrderBy .Limit}} ORDER BY {{.OrderBy}} LIMIT {{$.Limit}}{{end}}",
+			expected: "SELECT AccessKey FROM `project.dataset.table` WHERE Owner = @Owner ORDER BY CreatedAt DESC LIMIT 10",
+		},
+		{
+			name:     "root variable",
+			template: "SELECT {{.Columns}} FROM {{.Table}}{{with .Where}} WHERE {{.}} AND {{$.Where}}{{end}}",
+			expected: "SELECT AccessKey FROM `project.dataset.table` WHERE Owner = @Owner AND Owner = @Owner",
+		},
+		{
+			name:     "unknown slot",
+			template: "SELECT {{.Columns}} FROM {{.Table}} WHERE {{.Where}} {{.Group}}",
+			wantErr:  "invalid query template: unknown slot {{.Group}}",
+		},
+		{
+			name:     "field of a slot",
+			template: "SELECT {{.Columns.Foo}} FROM {{.Table}} WHERE {{.Where}}",
+			wantErr:  "invalid query template: slot {{.Columns}} has no field Foo",
+		},
+		{
+			name:     "field of a root variable slot",
+			template: "SELECT {{.Columns}} FROM {{.Table}} WHERE {{.Where}}{{with .Limit}} LIMIT {{$.Limit.N}}{{end}}",
+			wantErr:  "invalid query template: slot {{.Limit}} has no field N",
+		},
+		{
+			name:     "unguarded optional slot",
+			template: "SELECT {{.Columns}} FROM {{.Table}} WHERE {{.Where}} ORDER BY {{.OrderBy}}",
+			wantErr:  "invalid query template: optional slot {{.OrderBy}} must be inside {{if .OrderBy}} or {{with .OrderBy}}",
+		},
+		{
+			name:     "optional slot guarded by another slot",
+			template: "SELECT {{.Columns}} FROM {{.Table}} WHERE {{.Where}}{{if .OrderBy}} LIMIT {{.Limit}}{{end}}",
+			wantErr:  "invalid query template: optional slot {{.Limit}} must be inside {{if .Limit}} or {{with .Limit}}",
+		},
+		{
+			name:     "optional slot in the else branch",
+			template: "SELECT {{.Columns}} FROM {{.Table}} WHERE {{.Where}}{{if .Limit}}{{else}} LIMIT {{.Limit}}{{end}}",
+			wantErr:  "invalid query template: optional slot {{.Limit}} must be inside {{if .Limit}} or {{with .Limit}}",
+		},
+		{
+			name:     "missing required slot",
+			template: "SELECT {{.Columns}} FROM {{.Table}}",
+			wantErr:  "invalid query template: missing required slot {{.Where}}",
+		},
+		{
+			name:     "syntax error",
+			template: "SELECT {{.Columns}} FROM {{.Table}}\nWHERE {{.Where}",
+			wantErr:  "invalid query template: query:2: bad character U+007D '}'",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			tmpl, err := ParseQueryTemplate(test.template)
+			if test.wantErr != "" {
+				assert.ErrorIs(t, err, ErrInvalidQueryTemplate)
+				assert.EqualError(t, err, test.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			query, err := tmpl.Execute(values)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, query)
+		})
+	}
+}
+
+func TestQueryBuilderSpecRenderQuery(t *testing.T) {
+	t.Parallel()
+	values := QueryTemplateValues{Columns: "AccessKey", Table: "`table`", Where: "Owner = @Owner"}
+
+	tests := []struct {
+		name     string
+		spec     QueryBuilderSpec
+		expected string
+		wantErr  error
+	}{
+		{
+			name:     "query template",
+			spec:     QueryBuilderSpec{QueryTemplate: "SELECT {{.Columns}} FROM {{.Table}} WHERE {{.Where}}"},
+			expected: "SELECT AccessKey FROM `table` WHERE Owner = @Owner",
+		},
+		{
+			name:     "legacy sql query",
+			spec:     QueryBuilderSpec{SQLQuery: "SELECT %s FROM %s WHERE %s"},
+			expected: "SELECT AccessKey FROM `table` WHERE Owner = @Owner",
+		},
+		{
+			name:     "legacy sql query with extra verbs",
+			spec:     QueryBuilderSpec{SQLQuery: "SELECT %s FROM %s WHERE %s%s"},
+			expected: "SELECT AccessKey FROM `table` WHERE Owner = @Owner",
+		},
+		{
+			name:    "legacy sql query without the where clause",
+			spec:    QueryBuilderSpec{SQLQuery: "SELECT %s FROM %s"},
+			wantErr: ErrInvalidSpec,
+		},
+		{
+			name: "both templates",
+			spec: QueryBuilderSpec{
+				SQLQuery:      "SELECT %s FROM %s WHERE %s",
+				QueryTemplate: "SELECT {{.Columns}} FROM {{.Table}} WHERE {{.Where}}",
+			},
+			wantErr: ErrInvalidSpec,
+		},
+		{
+			name:    "no template",
+			wantErr: ErrInvalidSpec,
+		},
+		{
+			name:    "invalid template",
+			spec:    QueryBuilderSpec{QueryTemplate: "SELECT {{.Columns}} FROM {{.Table}}"},
+			wantErr: ErrInvalidQueryTemplate,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			query, err := test.spec.RenderQuery(values)
+			assert.ErrorIs(t, err, test.wantErr)
+			assert.Equal(t, test.expected, query)
+		})
+	}
+}
+
+func TestQueryBuilderSpecValidate(t *testing.T) {
+	t.Parallel()
+	assert.NoError(t, QueryBuilderSpec{SQLQuery: "SELECT %s FROM %s WHERE %s"}.Validate())
+	assert.NoError(t, QueryBuilderSpec{QueryTemplate: "SELECT {{.Columns}} FROM {{.Table}} WHERE {{.Where}}"}.Validate())
+	assert.ErrorIs(t, QueryBuilderSpec{QueryTemplate: "SELECT {{.Columns}}"}.Validate(), ErrInvalidQueryTemplate)
+	assert.NoError(t, QueryBuilderSpec{SQLQuery: "SELECT %s FROM %s WHERE %s AND Name LIKE 'A%%'"}.Validate())
+	assert.EqualError(t, QueryBuilderSpec{SQLQuery: "SELECT %s FROM %s"}.Validate(),
+		"invalid query builder spec: SQLQuery has 2 %s verbs, expected at least 3 for the columns, the table and the where clause")
+	assert.EqualError(t, QueryBuilderSpec{SQLQuery: "SELECT %s FROM %s WHERE %s LIMIT %d"}.Validate(),
+		"invalid query builder spec: SQLQuery has the unsupported verb %d, only %s is supported")
+	assert.EqualError(t, QueryBuilderSpec{SQLQuery: "SELECT %s FROM %s WHERE %s AND 100%"}.Validate(),
+		"invalid query builder spec: SQLQuery ends with an incomplete verb")
+	assert.ErrorIs(t, QueryBuilderSpec{
+		RequiredPredicates: []RequiredPredicate{{Column: "Owner"}},
+	}.Validate(), ErrInvalidSpec)
+}
@@ -0,0 +1,135 @@
+package bigqueryutil
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/arquivei/foundationkit/errors"
+)
+
+// ErrInvalidSpec is returned when a QueryBuilderSpec is invalid.
+var ErrInvalidSpec = errors.New("invalid query builder spec")
+
+// Validate checks that the spec is usable. Specs should be validated once, when they are
+// constructed, instead of failing on every query.
+func (s QueryBuilderSpec) Validate() error {
+	if err := s.checkQuerySources(); err != nil {
+		return err
+	}
+	if err := s.checkSQLQuery(); err != nil {
+		return err
+	}
+	if s.Dedup != nil {
+		if err := s.Dedup.validate(); err != nil {
+			return err
+		}
+	}
+	if s.queryTemplateText() != "" {
+		if _, err := s.parsedQueryTemplate(); err != nil {
+			return err
+		}
+	}
+	if err := s.checkComputedColumnPolicies(); err != nil {
+		return err
+	}
+	for _, p := range s.RequiredPredicates {
+		if !isValidColumnPath(p.Column) {
+			return errors.Errorf("%w: invalid required predicate column %q", ErrInvalidSpec, p.Column)
+		}
+		if p.Value == nil {
+			return errors.Errorf("%w: required predicate %s has no value function", ErrInvalidSpec, p.Column)
+		}
+	}
+	return nil
+}
+
+// RenderQuery returns the spec's query with the values in its slots. Specs with a legacy
+// SQLQuery get the columns clause, the table and the where clause in its first three %s verbs,
+// and any further %s verbs are left empty.
+//
+// The query template is parsed on every call. Builders parse it once, when they are created.
+func (s QueryBuilderSpec) RenderQuery(values QueryTemplateValues) (string, error) {
+	if err := s.checkQuerySources(); err != nil {
+		return "", err
+	}
+	if s.queryTemplateText() != "" {
+		t, err := s.parsedQueryTemplate()
+		if err != nil {
+			return "", err
+		}
+		return t.Execute(values)
+	}
+	if s.SQLQuery == "" {
+		return "", errors.Errorf("%w: one of SQLQuery, QueryTemplate and Dedup must be set", ErrInvalidSpec)
+	}
+	if err := s.checkSQLQuery(); err != nil {
+		return "", err
+	}
+
+	args := []interface{}{values.Columns, values.Table, values.Where}
+	for n := strings.Count(s.SQLQuery, "%s"); len(args) < n; {
+		args = append(args, "")
+	}
+	return fmt.Sprintf(s.SQLQuery, args...), nil
+}
+
+// legacyQueryVerbs is the number of %s verbs filled by RenderQuery in legacy SQLQuery specs.
+const legacyQueryVerbs = 3
+
+// checkSQLQuery checks that the legacy SQLQuery has at least the three %s verbs
+// filled by RenderQuery, and no other verbs but the escaped percent sign.
+func (s QueryBuilderSpec) checkSQLQuery() error {
+	if s.SQLQuery == "" {
+		return nil
+	}
+	n := 0
+	for i := 0; i < len(s.SQLQuery); i++ {
+		if s.SQLQuery[i] != '%' {
+			continue
+		}
+		i++
+		if i == len(s.SQLQuery) {
+			return errors.Errorf("%w: SQLQuery ends with an incomplete verb", ErrInvalidSpec)
+		}
+		switch s.SQLQuery[i] {
+		case 's':
+			n++
+		case '%':
+		default:
+			return errors.Errorf("%w: SQLQuery has the unsupported verb %%%c, only %%s is supported", ErrInvalidSpec, s.SQLQuery[i])
+		}
+	}
+	if n < legacyQueryVerbs {
+		return errors.Errorf("%w: SQLQuery has %d %%s verbs, expected at least %d for the columns, the table and the where clause",
+			ErrInvalidSpec, n, legacyQueryVerbs)
+	}
+	return nil
+}
+
+// hasQuery checks if the spec defines a query.
+func (s QueryBuilderSpec) hasQuery() bool {
+	return s.SQLQuery != "" || s.QueryTemplate != "" || s.Dedup != nil
+}
+
+// checkQuerySources checks that at most one of the ways of defining the query is set.
+func (s QueryBuilderSpec) checkQuerySources() error {
+	n := 0
+	for _, set := range []bool{s.SQLQuery != "", s.QueryTemplate != "", s.Dedup != nil} {
+		if set {
+			n++
+		}
+	}
+	if n > 1 {
+		return errors.Errorf("%w: only one of SQLQuery, QueryTemplate and Dedup may be set", ErrInvalidSpec)
+	}
+	return nil
+}
+
+// queryTemplateText returns the text of the spec's query template, either given by
+// QueryTemplate or generated by Dedup. It's empty for legacy SQLQuery specs.
+func (s QueryBuilderSpec) queryTemplateText() string {
+	if s.Dedup != nil {
+		return s.Dedup.queryTemplate()
+	}
+	return s.QueryTemplate
+}
@@ -0,0 +1,181 @@
+package bigqueryutil
+
+import (
+	"context"
+	"io/fs"
+	"sync/atomic"
+	"time"
+
+	"github.com/arquivei/foundationkit/errors"
+)
+
+// ErrEntityNotFound is returned when the registry has no entry for an entity and version.
+var ErrEntityNotFound = errors.New("entity not found")
+
+// Registry resolves the builders of entities by name and schema version.
+// It is safe for concurrent use, and its entries may be replaced while it's used.
+type Registry struct {
+	entries atomic.Pointer[registryEntries]
+}
+
+// registryEntries is an immutable set of entries, replaced as a whole.
+type registryEntries struct {
+	builders map[registryKey]*registryBuilder
+	defaults map[string]*registryBuilder
+}
+
+type registryKey struct {
+	entity  string
+	version string
+}
+
+type registryBuilder struct {
+	*Builder
+	entry RegistryEntry
+}
+
+// NewRegistry returns a registry with the entries.
+func NewRegistry(entries ...RegistryEntry) (*Registry, error) {
+	r := &Registry{}
+	if err := r.Replace(entries); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Replace atomically replaces all the entries of the registry. If any entry is invalid,
+// the registry is left unchanged.
+func (r *Registry) Replace(entries []RegistryEntry) error {
+	next := &registryEntries{
+		builders: make(map[registryKey]*registryBuilder, len(entries)),
+		defaults: make(map[string]*registryBuilder, len(entries)),
+	}
+	versions := make(map[string]int, len(entries))
+
+	for _, e := range entries {
+		if e.Entity == "" {
+			return errors.New("registry entry has no entity")
+		}
+		key := registryKey{entity: e.Entity, version: e.Version}
+		if _, ok := next.builders[key]; ok {
+			return errors.Errorf("duplicated registry entry %s version %q", e.Entity, e.Version)
+		}
+		builder, err := NewBuilder(e.Spec, e.Table)
+		if err != nil {
+			return errors.Errorf("registry entry %s version %q: %w", e.Entity, e.Version, err)
+		}
+		rb := &registryBuilder{Builder: builder, entry: e}
+		next.builders[key] = rb
+		versions[e.Entity]++
+
+		if e.Default {
+			if _, ok := next.defaults[e.Entity]; ok {
+				return errors.Errorf("entity %s has more than one default version", e.Entity)
+			}
+			next.defaults[e.Entity] = rb
+		}
+	}
+
+	// Entities with a single version default to it.
+	for key, rb := range next.builders {
+		if _, ok := next.defaults[key.entity]; !ok && versions[key.entity] == 1 {
+			next.defaults[key.entity] = rb
+		}
+	}
+
+	r.entries.Store(next)
+	return nil
+}
+
+// Reload replaces the entries of the registry by the ones loaded from the files matching
+// the pattern. See LoadRegistryEntries. The entries are kept if loading fails, including
+// when no file matches the pattern.
+func (r *Registry) Reload(fsys fs.FS, pattern string) error {
+	entries, err := LoadRegistryEntries(fsys, pattern)
+	if err != nil {
+		return err
+	}
+	return r.Replace(entries)
+}
+
+// ReloadEvery reloads the registry from the files matching the pattern on every interval, until
+// the context is done. Failed reloads keep the previous entries, and their errors are passed to
+// onError, if it's not nil.
+//
+// It returns an error right away if the interval is not positive, and nil when the context is done.
+func (r *Registry) ReloadEvery(ctx context.Context, fsys fs.FS, pattern string, interval time.Duration, onError func(error)) error {
+	if interval <= 0 {
+		return errors.New("invalid registry reload interval: " + interval.String())
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := r.Reload(fsys, pattern); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+// Entry returns the entry of the entity's version. An empty version resolves the default version.
+func (r *Registry) Entry(entity, version string) (RegistryEntry, error) {
+	rb, err := r.resolve(entity, version)
+	if err != nil {
+		return RegistryEntry{}, err
+	}
+	return rb.entry, nil
+}
+
+// Builder returns the builder of the entity's version. An empty version resolves the default version.
+func (r *Registry) Builder(entity, version string) (*Builder, error) {
+	rb, err := r.resolve(entity, version)
+	if err != nil {
+		return nil, err
+	}
+	return rb.Builder, nil
+}
+
+// Build builds the request with the builder of the entity's version, after applying the
+// entry's defaults to it. An empty version resolves the default version.
+func (r *Registry) Build(ctx context.Context, entity, version string, req QueryRequest) (BuiltQuery, error) {
+	rb, err := r.resolve(entity, version)
+	if err != nil {
+		return BuiltQuery{}, err
+	}
+	return rb.Build(ctx, rb.entry.Defaults.apply(req))
+}
+
+func (r *Registry) resolve(entity, version string) (*registryBuilder, error) {
+	entries := r.entries.Load()
+	if entries == nil {
+		return nil, errors.Errorf("%w: %s", ErrEntityNotFound, entity)
+	}
+	if version == "" {
+		if rb, ok := entries.defaults[entity]; ok {
+			return rb, nil
+		}
+		return nil, errors.Errorf("%w: %s has no default version", ErrEntityNotFound, entity)
+	}
+	if rb, ok := entries.builders[registryKey{entity: entity, version: version}]; ok {
+		return rb, nil
+	}
+	return nil, errors.Errorf("%w: %s version %q", ErrEntityNotFound, entity, version)
+}
+
+// apply returns the request with the defaults in the fields it doesn't set.
+func (d QueryDefaults) apply(req QueryRequest) QueryRequest {
+	if len(req.Projection) == 0 {
+		req.Projection = d.Projection
+	}
+	if len(req.OrderBy) == 0 {
+		req.OrderBy = d.OrderBy
+	}
+	if req.Limit == 0 {
+		req.Limit = d.Limit
+	}
+	return req
+}
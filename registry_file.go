@@ -0,0 +1,133 @@
+package bigqueryutil
+
+import (
+	"io/fs"
+
+	"github.com/arquivei/foundationkit/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// ParseRegistryEntry parses a RegistryEntry from a YAML or JSON document, like:
+//
+//	entity: nfe
+//	version: v2
+//	default: true
+//	table: my-project.documents.nfe_v2
+//	defaults:
+//	  projection: [AccessKey, NFe.infNFe.emit.CNPJ AS EmitterCNPJ]
+//	  orderBy: [CreatedAt DESC]
+//	  limit: 100
+//	spec:
+//	  repeatedColumns: [NFe.infNFe.det]
+//	  dedup:
+//	    keys: [AccessKey, Owner]
+//	    orderBy: [Version DESC]
+//
+// The spec has the same fields as the documents parsed by ParseSpec.
+func ParseRegistryEntry(data []byte) (RegistryEntry, error) {
+	var f registryFile
+	if err := decodeSpecDocument(data, &f); err != nil {
+		return RegistryEntry{}, err
+	}
+	if f.Entity == "" {
+		return RegistryEntry{}, errors.Errorf("%w: missing entity", ErrInvalidSpec)
+	}
+	if f.Table.Table == "" {
+		return RegistryEntry{}, errors.Errorf("%w: missing table", ErrInvalidSpec)
+	}
+
+	entry := RegistryEntry{
+		Entity:  f.Entity,
+		Version: f.Version,
+		Default: f.Default,
+		Spec:    f.Spec.spec(),
+		Table:   TableRef(f.Table),
+		Defaults: QueryDefaults{
+			Limit: f.Defaults.Limit,
+		},
+	}
+	for _, p := range f.Defaults.Projection {
+		entry.Defaults.Projection = append(entry.Defaults.Projection, ProjectionField(p))
+	}
+	for _, o := range f.Defaults.OrderBy {
+		entry.Defaults.OrderBy = append(entry.Defaults.OrderBy, OrderBy(o))
+	}
+	if err := entry.Spec.Validate(); err != nil {
+		return RegistryEntry{}, err
+	}
+	return entry, nil
+}
+
+// LoadRegistryEntries reads and parses the entries of the files matching the pattern, as
+// taken by fs.Glob, like "specs/*.yaml". See ParseRegistryEntry.
+//
+// It fails if no file matches the pattern, as a missing directory or a deploy caught halfway
+// would otherwise make Reload remove every entity.
+func LoadRegistryEntries(fsys fs.FS, pattern string) ([]RegistryEntry, error) {
+	paths, err := fs.Glob(fsys, pattern)
+	if err != nil {
+		return nil, errors.Errorf("listing registry entries: %w", err)
+	}
+	if len(paths) == 0 {
+		return nil, errors.Errorf("no registry entries match %q", pattern)
+	}
+	entries := make([]RegistryEntry, 0, len(paths))
+	for _, path := range paths {
+		data, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return nil, errors.Errorf("reading registry entry: %w", err)
+		}
+		entry, err := ParseRegistryEntry(data)
+		if err != nil {
+			return nil, errors.Errorf("%s: %w", path, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// registryFile is the document of a RegistryEntry.
+type registryFile struct {
+	Entity   string       `yaml:"entity"`
+	Version  string       `yaml:"version"`
+	Default  bool         `yaml:"default"`
+	Table    specTableRef `yaml:"table"`
+	Defaults struct {
+		Projection []specProjectionField `yaml:"projection"`
+		OrderBy    []specOrderBy         `yaml:"orderBy"`
+		Limit      int                   `yaml:"limit"`
+	} `yaml:"defaults"`
+	Spec specFile `yaml:"spec"`
+}
+
+// specTableRef is a table reference, parsed while decoded.
+type specTableRef TableRef
+
+func (r *specTableRef) UnmarshalYAML(n *yaml.Node) error {
+	var s string
+	if err := n.Decode(&s); err != nil {
+		return err
+	}
+	ref, err := ParseTableRef(s)
+	if err != nil {
+		return errors.Errorf("line %d: %w", n.Line, err)
+	}
+	*r = specTableRef(ref)
+	return nil
+}
+
+// specProjectionField is a projection field, like "-RawXML" or "NFe.infNFe.emit.CNPJ AS EmitterCNPJ".
+type specProjectionField ProjectionField
+
+func (f *specProjectionField) UnmarshalYAML(n *yaml.Node) error {
+	var s string
+	if err := n.Decode(&s); err != nil {
+		return err
+	}
+	field := ParseProjectionField(s)
+	if err := field.Validate(); err != nil {
+		return errors.Errorf("line %d: %w", n.Line, err)
+	}
+	*f = specProjectionField(field)
+	return nil
+}
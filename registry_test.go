@@ -0,0 +1,206 @@
+package bigqueryutil
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	nfeV1Entry = `
+entity: nfe
+version: v1
+table: my-project.documents.nfe_v1
+spec:
+  sqlQuery: SELECT %s FROM %s WHERE %s
+`
+	nfeV2Entry = `
+entity: nfe
+version: v2
+default: true
+table: my-project.documents.nfe_v2
+defaults:
+  projection: [AccessKey, NFe.infNFe.emit.CNPJ AS EmitterCNPJ]
+  orderBy: [CreatedAt DESC]
+  limit: 100
+spec:
+  dedup:
+    keys: [AccessKey]
+    orderBy: [Version DESC]
+    mode: qualify
+`
+	cteEntry = `
+entity: cte
+table: my-project.documents.cte
+spec:
+  queryTemplate: SELECT {{.Columns}} FROM {{.Table}} WHERE {{.Where}}
+`
+)
+
+func TestRegistry(t *testing.T) {
+	t.Parallel()
+	fsys := fstest.MapFS{
+		"specs/nfe_v1.yaml": {Data: []byte(nfeV1Entry)},
+		"specs/nfe_v2.yaml": {Data: []byte(nfeV2Entry)},
+		"specs/cte.yaml":    {Data: []byte(cteEntry)},
+	}
+	registry, err := NewRegistry()
+	assert.NoError(t, err)
+	assert.NoError(t, registry.Reload(fsys, "specs/*.yaml"))
+
+	tests := []struct {
+		name     string
+		entity   string
+		version  string
+		request  QueryRequest
+		expected string
+		wantErr  error
+	}{
+		{
+			name:     "explicit version",
+			entity:   "nfe",
+			version:  "v1",
+			request:  QueryRequest{Projection: []ProjectionField{{Path: "AccessKey"}}},
+			expected: "SELECT AccessKey FROM `my-project.documents.nfe_v1` WHERE TRUE",
+		},
+		{
+			name:   "default version with defaults",
+			entity: "nfe",
+			expected: "SELECT AccessKey,STRUCT(STRUCT(STRUCT(NFe.infNFe.emit.CNPJ AS EmitterCNPJ) AS emit) AS infNFe) AS NFe " +
+				"FROM `my-project.documents.nfe_v2` WHERE TRUE " +
+				"QUALIFY ROW_NUMBER() OVER (PARTITION BY AccessKey ORDER BY Version DESC) = 1 ORDER BY CreatedAt DESC LIMIT 100",
+		},
+		{
+			name:    "default version with request values",
+			entity:  "nfe",
+			request: QueryRequest{Projection: []ProjectionField{{Path: "AccessKey"}}, Limit: 5},
+			expected: "SELECT AccessKey FROM `my-project.documents.nfe_v2` WHERE TRUE " +
+				"QUALIFY ROW_NUMBER() OVER (PARTITION BY AccessKey ORDER BY Version DESC) = 1 ORDER BY CreatedAt DESC LIMIT 5",
+		},
+		{
+			name:     "single version",
+			entity:   "cte",
+			expected: "SELECT * FROM `my-project.documents.cte` WHERE TRUE",
+		},
+		{
+			name:    "unknown entity",
+			entity:  "nfse",
+			wantErr: ErrEntityNotFound,
+		},
+		{
+			name:    "unknown version",
+			entity:  "nfe",
+			version: "v3",
+			wantErr: ErrEntityNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			query, err := registry.Build(context.Background(), test.entity, test.version, test.request)
+			if test.wantErr != nil {
+				assert.ErrorIs(t, err, test.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, query.SQL)
+		})
+	}
+}
+
+func TestRegistryReload(t *testing.T) {
+	t.Parallel()
+	fsys := fstest.MapFS{
+		"specs/cte.yaml": {Data: []byte(cteEntry)},
+	}
+	registry, err := NewRegistry()
+	assert.NoError(t, err)
+
+	_, err = registry.Builder("cte", "")
+	assert.ErrorIs(t, err, ErrEntityNotFound)
+
+	assert.NoError(t, registry.Reload(fsys, "specs/*.yaml"))
+	entry, err := registry.Entry("cte", "")
+	assert.NoError(t, err)
+	assert.Equal(t, TableRef{Project: "my-project", Dataset: "documents", Table: "cte"}, entry.Table)
+
+	// A failed reload keeps the previous entries.
+	fsys["specs/nfe.yaml"] = &fstest.MapFile{Data: []byte("entity: nfe\ntable: my-project.documents.nfe\nspec:\n  repeatedColumns: [NFe det]\n")}
+	err = registry.Reload(fsys, "specs/*.yaml")
+	assert.ErrorIs(t, err, ErrInvalidSpec)
+	assert.EqualError(t, err, `specs/nfe.yaml: invalid query builder spec: line 4: invalid column path "NFe det"`)
+	_, err = registry.Builder("cte", "")
+	assert.NoError(t, err)
+
+	fsys["specs/nfe.yaml"] = &fstest.MapFile{Data: []byte(nfeV1Entry)}
+	assert.NoError(t, registry.Reload(fsys, "specs/*.yaml"))
+	_, err = registry.Builder("nfe", "v1")
+	assert.NoError(t, err)
+
+	// A reload matching no files keeps the previous entries too.
+	err = registry.Reload(fstest.MapFS{}, "specs/*.yaml")
+	assert.EqualError(t, err, `no registry entries match "specs/*.yaml"`)
+	_, err = registry.Builder("nfe", "v1")
+	assert.NoError(t, err)
+}
+
+func TestRegistryReplace(t *testing.T) {
+	t.Parallel()
+	spec := QueryBuilderSpec{SQLQuery: "SELECT %s FROM %s WHERE %s"}
+	table := TableRef{Dataset: "documents", Table: "nfe"}
+
+	_, err := NewRegistry(
+		RegistryEntry{Entity: "nfe", Version: "v1", Spec: spec, Table: table},
+		RegistryEntry{Entity: "nfe", Version: "v1", Spec: spec, Table: table},
+	)
+	assert.Error(t, err)
+
+	_, err = NewRegistry(
+		RegistryEntry{Entity: "nfe", Version: "v1", Default: true, Spec: spec, Table: table},
+		RegistryEntry{Entity: "nfe", Version: "v2", Default: true, Spec: spec, Table: table},
+	)
+	assert.Error(t, err)
+
+	registry, err := NewRegistry(
+		RegistryEntry{Entity: "nfe", Version: "v1", Spec: spec, Table: table},
+		RegistryEntry{Entity: "nfe", Version: "v2", Spec: spec, Table: table},
+	)
+	assert.NoError(t, err)
+	_, err = registry.Builder("nfe", "")
+	assert.ErrorIs(t, err, ErrEntityNotFound)
+	_, err = registry.Builder("nfe", "v2")
+	assert.NoError(t, err)
+}
+
+func TestRegistryReloadEvery(t *testing.T) {
+	t.Parallel()
+	fsys := fstest.MapFS{
+		"specs/cte.yaml": {Data: []byte(cteEntry)},
+	}
+	registry, err := NewRegistry()
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.NoError(t, registry.ReloadEvery(ctx, fsys, "specs/*.yaml", time.Millisecond, nil))
+	}()
+
+	// The reloads replace the entries by the ones of the file system.
+	assert.Eventually(t, func() bool {
+		_, err := registry.Builder("cte", "")
+		return err == nil
+	}, time.Second, time.Millisecond)
+
+	cancel()
+	<-done
+
+	err = registry.ReloadEvery(context.Background(), fsys, "specs/*.yaml", 0, nil)
+	assert.EqualError(t, err, "invalid registry reload interval: 0s")
+}
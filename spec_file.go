@@ -0,0 +1,352 @@
+package bigqueryutil
+
+import (
+	"bytes"
+	stderrors "errors"
+	"io"
+	"io/fs"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/arquivei/foundationkit/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// ParseSpec parses a QueryBuilderSpec from a YAML or JSON document, like:
+//
+//	repeatedColumns:
+//	  - NFe.infNFe.det
+//	queryTemplate: SELECT {{.Columns}} FROM {{.Table}} WHERE {{.Where}}
+//	computedColumns:
+//	  EventsCount: ARRAY_LENGTH(Events)
+//	dedup:
+//	  keys: [AccessKey, Owner]
+//	  orderBy: [Version DESC]
+//	  mode: qualify # or subquery, the default
+//	columnPolicies:
+//	  NFe.infNFe.dest.CPF:
+//	    default: maskSHA256 # or allow, deny, maskNull
+//	    roles:
+//	      admin: allow
+//
+// Unknown fields, invalid column paths, templates, SQL queries and dedup specs, and computed
+// columns without a policy are reported with their line numbers.
+// Required predicates read their values from requests, so they can't be loaded from documents.
+func ParseSpec(data []byte) (QueryBuilderSpec, error) {
+	var f specFile
+	if err := decodeSpecDocument(data, &f); err != nil {
+		return QueryBuilderSpec{}, err
+	}
+
+	spec := f.spec()
+	if err := spec.Validate(); err != nil {
+		return QueryBuilderSpec{}, err
+	}
+	return spec, nil
+}
+
+// LoadSpecFile reads and parses a QueryBuilderSpec from a YAML or JSON file. See ParseSpec.
+func LoadSpecFile(path string) (QueryBuilderSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return QueryBuilderSpec{}, errors.Errorf("reading spec: %w", err)
+	}
+	return parseSpecFile(path, data)
+}
+
+// LoadSpecFS reads and parses a QueryBuilderSpec from a YAML or JSON file of the file system,
+// like an embed.FS. See ParseSpec.
+func LoadSpecFS(fsys fs.FS, path string) (QueryBuilderSpec, error) {
+	data, err := fs.ReadFile(fsys, path)
+	if err != nil {
+		return QueryBuilderSpec{}, errors.Errorf("reading spec: %w", err)
+	}
+	return parseSpecFile(path, data)
+}
+
+// parseSpecFile parses the spec, prefixing the errors with the path of the file.
+func parseSpecFile(path string, data []byte) (QueryBuilderSpec, error) {
+	spec, err := ParseSpec(data)
+	if err != nil {
+		return QueryBuilderSpec{}, errors.Errorf("%s: %w", path, err)
+	}
+	return spec, nil
+}
+
+// decodeSpecDocument decodes the YAML or JSON document into v, failing on unknown fields.
+func decodeSpecDocument(data []byte, v interface{}) error {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(v); err != nil {
+		if stderrors.Is(err, io.EOF) {
+			return errors.Errorf("%w: empty document", ErrInvalidSpec)
+		}
+		return errors.Errorf("%w: %s", ErrInvalidSpec, yamlErrorMessage(err))
+	}
+	return nil
+}
+
+// yamlErrorMessage returns the message of the decoding error, without the "yaml:" prefixes.
+func yamlErrorMessage(err error) string {
+	var typeErr *yaml.TypeError
+	if stderrors.As(err, &typeErr) {
+		return strings.Join(typeErr.Errors, "; ")
+	}
+	return strings.TrimPrefix(err.Error(), "yaml: ")
+}
+
+// specFile is the document of a QueryBuilderSpec.
+type specFile struct {
+	RepeatedColumns []specColumnPath                    `yaml:"repeatedColumns"`
+	SQLQuery        specSQLQuery                        `yaml:"sqlQuery"`
+	QueryTemplate   specQueryTemplate                   `yaml:"queryTemplate"`
+	ComputedColumns map[specColumnPath]string           `yaml:"computedColumns"`
+	ColumnPolicies  map[specColumnPath]specColumnPolicy `yaml:"columnPolicies"`
+	Dedup           *specDedup                          `yaml:"dedup"`
+}
+
+// specFields has the fields of a specFile, without its UnmarshalYAML method.
+type specFields specFile
+
+func (f *specFile) UnmarshalYAML(n *yaml.Node) error {
+	if err := decodeSpecNode(n, f, (*specFields)(f)); err != nil {
+		return err
+	}
+	if len(f.ColumnPolicies) == 0 {
+		return nil
+	}
+	// Computed columns must have a policy of their own, see computedColumnAccess.
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value != "computedColumns" {
+			continue
+		}
+		columns := n.Content[i+1]
+		for j := 0; j+1 < len(columns.Content); j += 2 {
+			c := columns.Content[j]
+			if _, ok := f.ColumnPolicies[specColumnPath(c.Value)]; !ok {
+				return errors.Errorf("line %d: computed column %s has no column policy", c.Line, c.Value)
+			}
+		}
+	}
+	return nil
+}
+
+func (f specFile) spec() QueryBuilderSpec {
+	spec := QueryBuilderSpec{
+		SQLQuery:      string(f.SQLQuery),
+		QueryTemplate: string(f.QueryTemplate),
+	}
+	if len(f.RepeatedColumns) > 0 {
+		spec.RepeatedColumns = make(map[string]struct{}, len(f.RepeatedColumns))
+		for _, c := range f.RepeatedColumns {
+			spec.RepeatedColumns[string(c)] = struct{}{}
+		}
+	}
+	if len(f.ComputedColumns) > 0 {
+		spec.ComputedColumns = make(map[string]string, len(f.ComputedColumns))
+		for c, expr := range f.ComputedColumns {
+			spec.ComputedColumns[string(c)] = expr
+		}
+	}
+	if len(f.ColumnPolicies) > 0 {
+		spec.ColumnPolicies = make(map[string]ColumnPolicy, len(f.ColumnPolicies))
+		for c, p := range f.ColumnPolicies {
+			policy := ColumnPolicy{Default: ColumnAccess(p.Default)}
+			if len(p.Roles) > 0 {
+				policy.Roles = make(map[string]ColumnAccess, len(p.Roles))
+				for role, access := range p.Roles {
+					policy.Roles[role] = ColumnAccess(access)
+				}
+			}
+			spec.ColumnPolicies[string(c)] = policy
+		}
+	}
+	if f.Dedup != nil {
+		dedup := &DedupSpec{Mode: DedupMode(f.Dedup.Mode)}
+		for _, k := range f.Dedup.Keys {
+			dedup.Keys = append(dedup.Keys, string(k))
+		}
+		for _, o := range f.Dedup.OrderBy {
+			dedup.OrderBy = append(dedup.OrderBy, OrderBy(o))
+		}
+		spec.Dedup = dedup
+	}
+	return spec
+}
+
+type specColumnPolicy struct {
+	Default specColumnAccess            `yaml:"default"`
+	Roles   map[string]specColumnAccess `yaml:"roles"`
+}
+
+// specColumnPolicyFields has the fields of a specColumnPolicy, without its UnmarshalYAML method.
+type specColumnPolicyFields specColumnPolicy
+
+func (p *specColumnPolicy) UnmarshalYAML(n *yaml.Node) error {
+	return decodeSpecNode(n, p, (*specColumnPolicyFields)(p))
+}
+
+// decodeSpecNode decodes the node into fields, the fields of out, failing on the fields out doesn't have.
+// The decoder of the document fails on unknown fields, but Node.Decode, used by the structs that
+// validate themselves while decoded, doesn't, so these structs and the ones they have must check them.
+func decodeSpecNode(n *yaml.Node, out interface{}, fields interface{}) error {
+	if n.Kind == yaml.MappingNode {
+		t := reflect.TypeOf(out).Elem()
+		known := make(map[string]bool, t.NumField())
+		for i := 0; i < t.NumField(); i++ {
+			name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+			known[name] = true
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if k := n.Content[i]; !known[k.Value] {
+				return errors.Errorf("line %d: field %s not found in type %s", k.Line, k.Value, t)
+			}
+		}
+	}
+	return n.Decode(fields)
+}
+
+type specDedup struct {
+	Keys    []specColumnPath `yaml:"keys"`
+	OrderBy []specOrderBy    `yaml:"orderBy"`
+	Mode    specDedupMode    `yaml:"mode"`
+}
+
+// specDedupFields has the fields of a specDedup, without its UnmarshalYAML method.
+type specDedupFields specDedup
+
+func (d *specDedup) UnmarshalYAML(n *yaml.Node) error {
+	if err := decodeSpecNode(n, d, (*specDedupFields)(d)); err != nil {
+		return err
+	}
+	dedup := DedupSpec{Mode: DedupMode(d.Mode)}
+	for _, k := range d.Keys {
+		dedup.Keys = append(dedup.Keys, string(k))
+	}
+	for _, o := range d.OrderBy {
+		dedup.OrderBy = append(dedup.OrderBy, OrderBy(o))
+	}
+	if err := dedup.validate(); err != nil {
+		return specLineError(n.Line, err)
+	}
+	return nil
+}
+
+// specLineError returns the ErrInvalidSpec error of the spec's validation as the error of
+// the line, which is wrapped with ErrInvalidSpec once the document is decoded.
+func specLineError(line int, err error) error {
+	return errors.Errorf("line %d: %s", line, strings.TrimPrefix(err.Error(), ErrInvalidSpec.Error()+": "))
+}
+
+// specColumnPath is a column path, validated while decoded.
+type specColumnPath string
+
+func (p *specColumnPath) UnmarshalYAML(n *yaml.Node) error {
+	var s string
+	if err := n.Decode(&s); err != nil {
+		return err
+	}
+	if !isValidColumnPath(s) {
+		return errors.Errorf("line %d: invalid column path %q", n.Line, s)
+	}
+	*p = specColumnPath(s)
+	return nil
+}
+
+// specQueryTemplate is a query template, validated while decoded.
+type specQueryTemplate string
+
+func (t *specQueryTemplate) UnmarshalYAML(n *yaml.Node) error {
+	var s string
+	if err := n.Decode(&s); err != nil {
+		return err
+	}
+	if _, err := ParseQueryTemplate(s); err != nil {
+		return errors.Errorf("line %d: %w", n.Line, err)
+	}
+	*t = specQueryTemplate(s)
+	return nil
+}
+
+// specSQLQuery is a legacy SQL query, validated while decoded.
+type specSQLQuery string
+
+func (q *specSQLQuery) UnmarshalYAML(n *yaml.Node) error {
+	var s string
+	if err := n.Decode(&s); err != nil {
+		return err
+	}
+	if err := (QueryBuilderSpec{SQLQuery: s}).checkSQLQuery(); err != nil {
+		return specLineError(n.Line, err)
+	}
+	*q = specSQLQuery(s)
+	return nil
+}
+
+// specOrderBy is an ORDER BY item, like "Version DESC".
+type specOrderBy OrderBy
+
+func (o *specOrderBy) UnmarshalYAML(n *yaml.Node) error {
+	var s string
+	if err := n.Decode(&s); err != nil {
+		return err
+	}
+	parts := strings.Fields(s)
+	if len(parts) == 0 || len(parts) > 2 || !isValidColumnPath(parts[0]) {
+		return errors.Errorf("line %d: invalid order by %q", n.Line, s)
+	}
+	*o = specOrderBy{Column: parts[0]}
+	if len(parts) == 2 {
+		switch strings.ToUpper(parts[1]) {
+		case "ASC":
+		case "DESC":
+			o.Descending = true
+		default:
+			return errors.Errorf("line %d: invalid order by direction %q", n.Line, parts[1])
+		}
+	}
+	return nil
+}
+
+// specColumnAccess is a ColumnAccess, decoded from one of allow, deny, maskSHA256 and maskNull.
+type specColumnAccess ColumnAccess
+
+func (a *specColumnAccess) UnmarshalYAML(n *yaml.Node) error {
+	var s string
+	if err := n.Decode(&s); err != nil {
+		return err
+	}
+	switch s {
+	case "allow":
+		*a = specColumnAccess(ColumnAllow)
+	case "deny":
+		*a = specColumnAccess(ColumnDeny)
+	case "maskSHA256":
+		*a = specColumnAccess(ColumnMaskSHA256)
+	case "maskNull":
+		*a = specColumnAccess(ColumnMaskNull)
+	default:
+		return errors.Errorf("line %d: unknown column access %q, expected allow, deny, maskSHA256 or maskNull", n.Line, s)
+	}
+	return nil
+}
+
+// specDedupMode is a DedupMode, decoded from one of subquery and qualify.
+type specDedupMode DedupMode
+
+func (m *specDedupMode) UnmarshalYAML(n *yaml.Node) error {
+	var s string
+	if err := n.Decode(&s); err != nil {
+		return err
+	}
+	switch s {
+	case "subquery":
+		*m = specDedupMode(DedupSubquery)
+	case "qualify":
+		*m = specDedupMode(DedupQualify)
+	default:
+		return errors.Errorf("line %d: unknown dedup mode %q, expected subquery or qualify", n.Line, s)
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package bigqueryutil
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSpec(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		document string
+		expected QueryBuilderSpec
+		wantErr  string
+	}{
+		{
+			name: "yaml",
+			document: `
+repeatedColumns:
+  - NFe.infNFe.det
+  - Events
+computedColumns:
+  EventsCount: ARRAY_LENGTH(Events)
+dedup:
+  keys: [AccessKey, Owner]
+  orderBy: [Version DESC, CreatedAt]
+  mode: qualify
+columnPolicies:
+  NFe.infNFe.dest.CPF:
+    default: maskSHA256
+    roles:
+      admin: allow
+  RawXML:
+    default: deny
+  EventsCount:
+    default: allow
+`,
+			expected: QueryBuilderSpec{
+				RepeatedColumns: map[string]struct{}{"NFe.infNFe.det": {}, "Events": {}},
+				ComputedColumns: map[string]string{"EventsCount": "ARRAY_LENGTH(Events)"},
+				Dedup: &DedupSpec{
+					Keys:    []string{"AccessKey", "Owner"},
+					OrderBy: []OrderBy{{Column: "Version", Descending: true}, {Column: "CreatedAt"}},
+					Mode:    DedupQualify,
+				},
+				ColumnPolicies: map[string]ColumnPolicy{
+					"NFe.infNFe.dest.CPF": {Default: ColumnMaskSHA256, Roles: map[string]ColumnAccess{"admin": ColumnAllow}},
+					"RawXML":              {Default: ColumnDeny},
+					"EventsCount":         {Default: ColumnAllow},
+				},
+			},
+		},
+		{
+			name: "json",
+			document: `{
+  "repeatedColumns": ["Events"],
+  "queryTemplate": "SELECT {{.Columns}} FROM {{.Table}} WHERE {{.Where}}"
+}`,
+			expected: QueryBuilderSpec{
+				RepeatedColumns: map[string]struct{}{"Events": {}},
+				QueryTemplate:   "SELECT {{.Columns}} FROM {{.Table}} WHERE {{.Where}}",
+			},
+		},
+		{
+			name:     "empty document",
+			document: "",
+			wantErr:  "invalid query builder spec: empty document",
+		},
+		{
+			name:     "unknown field",
+			document: "repeatedColumns: [Events]\nrepeatedColumn: [Events]\n",
+			wantErr:  "invalid query builder spec: line 2: field repeatedColumn not found in type bigqueryutil.specFile",
+		},
+		{
+			name:     "invalid column path",
+			document: "repeatedColumns:\n  - Events\n  - Events Date\n",
+			wantErr:  `invalid query builder spec: line 3: invalid column path "Events Date"`,
+		},
+		{
+			name:     "invalid template",
+			document: "\nqueryTemplate: SELECT {{.Columns}} FROM {{.Table}}\n",
+			wantErr:  "invalid query builder spec: line 2: invalid query template: missing required slot {{.Where}}",
+		},
+		{
+			name:     "unknown access",
+			document: "columnPolicies:\n  RawXML:\n    default: hide\n",
+			wantErr:  `invalid query builder spec: line 3: unknown column access "hide", expected allow, deny, maskSHA256 or maskNull`,
+		},
+		{
+			name:     "invalid order by",
+			document: "dedup:\n  keys: [AccessKey]\n  orderBy: [Version DOWN]\n",
+			wantErr:  `invalid query builder spec: line 3: invalid order by direction "DOWN"`,
+		},
+		{
+			name:     "syntax error",
+			document: "repeatedColumns: [Events\n",
+			wantErr:  "invalid query builder spec: line 1: did not find expected ',' or ']'",
+		},
+		{
+			name:     "computed column without policy",
+			document: "computedColumns:\n  EventsCount: ARRAY_LENGTH(Events)\ncolumnPolicies:\n  RawXML:\n    default: deny\n",
+			wantErr:  "invalid query builder spec: line 2: computed column EventsCount has no column policy",
+		},
+		{
+			name:     "dedup without keys",
+			document: "\ndedup:\n  keys: []\n  orderBy: [Version DESC]\n",
+			wantErr:  "invalid query builder spec: line 3: dedup requires at least one key",
+		},
+		{
+			name:     "invalid sql query",
+			document: "repeatedColumns: [Events]\nsqlQuery: SELECT %s FROM %s\n",
+			wantErr: "invalid query builder spec: line 2: SQLQuery has 2 %s verbs, " +
+				"expected at least 3 for the columns, the table and the where clause",
+		},
+		{
+			name:     "unknown dedup field",
+			document: "dedup:\n  keys: [AccessKey]\n  order: [Version DESC]\n",
+			wantErr:  "invalid query builder spec: line 3: field order not found in type bigqueryutil.specDedup",
+		},
+		{
+			name:     "unknown column policy field",
+			document: "columnPolicies:\n  RawXML:\n    default: deny\n    role: {admin: allow}\n",
+			wantErr:  "invalid query builder spec: line 4: field role not found in type bigqueryutil.specColumnPolicy",
+		},
+		{
+			name:     "invalid spec",
+			document: "sqlQuery: SELECT %s FROM %s WHERE %s\nqueryTemplate: SELECT {{.Columns}} FROM {{.Table}} WHERE {{.Where}}\n",
+			wantErr:  "invalid query builder spec: only one of SQLQuery, QueryTemplate and Dedup may be set",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			spec, err := ParseSpec([]byte(test.document))
+			if test.wantErr != "" {
+				assert.EqualError(t, err, test.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, spec)
+		})
+	}
+}
+
+func TestLoadSpecFS(t *testing.T) {
+	t.Parallel()
+	fsys := fstest.MapFS{
+		"specs/nfe.yaml":     {Data: []byte("repeatedColumns: [NFe.infNFe.det]\nsqlQuery: SELECT %s FROM %s WHERE %s\n")},
+		"specs/invalid.yaml": {Data: []byte("repeatedColumns: [NFe infNFe]\n")},
+	}
+
+	spec, err := LoadSpecFS(fsys, "specs/nfe.yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, QueryBuilderSpec{
+		RepeatedColumns: map[string]struct{}{"NFe.infNFe.det": {}},
+		SQLQuery:        "SELECT %s FROM %s WHERE %s",
+	}, spec)
+
+	_, err = LoadSpecFS(fsys, "specs/invalid.yaml")
+	assert.ErrorIs(t, err, ErrInvalidSpec)
+	assert.EqualError(t, err, `specs/invalid.yaml: invalid query builder spec: line 1: invalid column path "NFe infNFe"`)
+
+	_, err = LoadSpecFS(fsys, "specs/missing.yaml")
+	assert.Error(t, err)
+}
@@ -0,0 +1,105 @@
+package bigqueryutil
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/arquivei/foundationkit/errors"
+)
+
+// ErrInvalidTableRef is returned when a table reference doesn't follow BigQuery's naming rules.
+var ErrInvalidTableRef = errors.New("invalid table reference")
+
+// ParseTableRef parses a table reference like "project.dataset.table" or "dataset.table".
+// The reference may be quoted with backticks, and domain scoped projects, like
+// "example.com:project.dataset.table", are supported.
+func ParseTableRef(s string) (TableRef, error) {
+	ref := strings.TrimSpace(s)
+	if len(ref) >= 2 && ref[0] == '`' && ref[len(ref)-1] == '`' {
+		ref = ref[1 : len(ref)-1]
+	}
+
+	var r TableRef
+	i := strings.LastIndexByte(ref, '.')
+	if i < 0 {
+		return TableRef{}, errors.Errorf("%w: %q should be like project.dataset.table", ErrInvalidTableRef, s)
+	}
+	r.Table, ref = ref[i+1:], ref[:i]
+	if i = strings.LastIndexByte(ref, '.'); i < 0 {
+		r.Dataset = ref
+	} else {
+		r.Dataset, r.Project = ref[i+1:], ref[:i]
+	}
+
+	if err := r.Validate(); err != nil {
+		return TableRef{}, err
+	}
+	return r, nil
+}
+
+// Validate checks the parts of the reference against BigQuery's naming rules.
+//
+// Projects have 6 to 30 lowercase letters, digits and hyphens, start with a letter and don't
+// end with a hyphen, optionally prefixed by a domain, like "example.com:". Datasets have up to
+// 1024 letters, digits and underscores. Tables have up to 1024 bytes of letters, marks, numbers,
+// connectors, dashes and spaces, optionally ending with a "*" wildcard.
+func (r TableRef) Validate() error {
+	if r.Project != "" && !isValidProjectID(r.Project) {
+		return errors.Errorf("%w: invalid project %q", ErrInvalidTableRef, r.Project)
+	}
+	if !isValidDatasetID(r.Dataset) {
+		return errors.Errorf("%w: invalid dataset %q", ErrInvalidTableRef, r.Dataset)
+	}
+	if !isValidTableID(r.Table) {
+		return errors.Errorf("%w: invalid table %q", ErrInvalidTableRef, r.Table)
+	}
+	return nil
+}
+
+func isValidProjectID(id string) bool {
+	if i := strings.LastIndexByte(id, ':'); i >= 0 {
+		domain := id[:i]
+		if domain == "" || strings.ContainsAny(domain, "`:") {
+			return false
+		}
+		id = id[i+1:]
+	}
+	if len(id) < 6 || len(id) > 30 || id[0] < 'a' || id[0] > 'z' || id[len(id)-1] == '-' {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') && c != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+func isValidDatasetID(id string) bool {
+	if id == "" || len(id) > 1024 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if !isColumnNameChar(id[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isValidTableID(id string) bool {
+	id = strings.TrimSuffix(id, "*")
+	if id == "" || len(id) > 1024 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case unicode.IsLetter(c), unicode.IsMark(c), unicode.IsNumber(c),
+			unicode.Is(unicode.Pc, c), unicode.Is(unicode.Pd, c), unicode.Is(unicode.Zs, c):
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,108 @@
+package bigqueryutil
+
+import (
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTableRef(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		ref      string
+		expected TableRef
+		quoted   string
+		wantErr  bool
+	}{
+		{
+			name:     "full reference",
+			ref:      "my-project.dataset.table",
+			expected: TableRef{Project: "my-project", Dataset: "dataset", Table: "table"},
+			quoted:   "`my-project.dataset.table`",
+		},
+		{
+			name:     "quoted reference",
+			ref:      "`my-project.dataset.table`",
+			expected: TableRef{Project: "my-project", Dataset: "dataset", Table: "table"},
+			quoted:   "`my-project.dataset.table`",
+		},
+		{
+			name:     "without project",
+			ref:      "dataset.table",
+			expected: TableRef{Dataset: "dataset", Table: "table"},
+			quoted:   "`dataset.table`",
+		},
+		{
+			name:     "domain scoped project",
+			ref:      "example.com:my-project.dataset.table",
+			expected: TableRef{Project: "example.com:my-project", Dataset: "dataset", Table: "table"},
+			quoted:   "`example.com:my-project.dataset.table`",
+		},
+		{
+			name:     "unicode table and wildcard",
+			ref:      "my-project.dataset.notas-fiscais ção_*",
+			expected: TableRef{Project: "my-project", Dataset: "dataset", Table: "notas-fiscais ção_*"},
+			quoted:   "`my-project.dataset.notas-fiscais ção_*`",
+		},
+		{
+			name:    "table only",
+			ref:     "table",
+			wantErr: true,
+		},
+		{
+			name:    "short project",
+			ref:     "proj.dataset.table",
+			wantErr: true,
+		},
+		{
+			name:    "uppercase project",
+			ref:     "My-Project.dataset.table",
+			wantErr: true,
+		},
+		{
+			name:    "project ending with hyphen",
+			ref:     "my-project-.dataset.table",
+			wantErr: true,
+		},
+		{
+			name:    "invalid dataset",
+			ref:     "my-project.data-set.table",
+			wantErr: true,
+		},
+		{
+			name:    "empty table",
+			ref:     "my-project.dataset.",
+			wantErr: true,
+		},
+		{
+			name:    "backtick in table",
+			ref:     "my-project.dataset.ta`ble",
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			ref, err := ParseTableRef(test.ref)
+			if test.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidTableRef)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, ref)
+			assert.Equal(t, test.quoted, ref.String())
+		})
+	}
+}
+
+func TestTableRefFromTable(t *testing.T) {
+	t.Parallel()
+	table := &bigquery.Table{ProjectID: "my-project", DatasetID: "dataset", TableID: "table"}
+	ref := TableRefFromTable(table)
+	assert.Equal(t, TableRef{Project: "my-project", Dataset: "dataset", Table: "table"}, ref)
+	assert.NoError(t, ref.Validate())
+}